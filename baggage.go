@@ -0,0 +1,161 @@
+package opencensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"go.opencensus.io/tag"
+)
+
+// BaggageConfig enables the propagation of the W3C baggage header. The allowed entries of
+// the incoming requests are added to the request context as tags and injected into the
+// backend requests.
+type BaggageConfig struct {
+	// AllowedKeys lists the baggage keys to propagate. Any other entry is dropped
+	AllowedKeys []string `json:"allowed_keys"`
+	// MaxEntries caps the number of propagated entries. Defaults to 16
+	MaxEntries int `json:"max_entries"`
+	// MaxSize is the max length of the baggage header. Longer headers are ignored. Defaults to 8192
+	MaxSize int `json:"max_size"`
+	// MetricTags adds the allowed keys to the tags of the http/server and http/client views
+	MetricTags bool `json:"metric_tags"`
+}
+
+const (
+	baggageHeader            = "baggage"
+	defaultBaggageMaxEntries = 16
+	defaultBaggageMaxSize    = 8192
+)
+
+var errBaggageWithoutKeys = errors.New("baggage: missing allowed keys")
+
+var currentBaggage atomic.Pointer[baggage]
+
+type baggage struct {
+	keys       map[string]tag.Key
+	order      []tag.Key
+	maxEntries int
+	maxSize    int
+	metricTags bool
+}
+
+func newBaggage(cfg *BaggageConfig) (*baggage, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if len(cfg.AllowedKeys) == 0 {
+		return nil, errBaggageWithoutKeys
+	}
+	b := &baggage{
+		keys:       make(map[string]tag.Key, len(cfg.AllowedKeys)),
+		order:      make([]tag.Key, 0, len(cfg.AllowedKeys)),
+		maxEntries: cfg.MaxEntries,
+		maxSize:    cfg.MaxSize,
+		metricTags: cfg.MetricTags,
+	}
+	if b.maxEntries <= 0 {
+		b.maxEntries = defaultBaggageMaxEntries
+	}
+	if b.maxSize <= 0 {
+		b.maxSize = defaultBaggageMaxSize
+	}
+	for _, name := range cfg.AllowedKeys {
+		if _, ok := b.keys[name]; ok {
+			continue
+		}
+		k, err := tag.NewKey(name)
+		if err != nil {
+			return nil, fmt.Errorf("baggage: %w", err)
+		}
+		b.keys[name] = k
+		b.order = append(b.order, k)
+	}
+	return b, nil
+}
+
+// baggageTagKeys returns the keys to add to the http views
+func baggageTagKeys(b *baggage) []tag.Key {
+	if b == nil || !b.metricTags {
+		return nil
+	}
+	return b.order
+}
+
+// ExtractBaggage adds the allowed entries of the baggage header of the request to the
+// tags of its context
+func ExtractBaggage(r *http.Request) *http.Request {
+	b := currentBaggage.Load()
+	if b == nil {
+		return r
+	}
+	ctx, ok := b.extract(r.Context(), r.Header.Get(baggageHeader))
+	if !ok {
+		return r
+	}
+	return r.WithContext(ctx)
+}
+
+func (b *baggage) extract(ctx context.Context, header string) (context.Context, bool) {
+	if header == "" || len(header) > b.maxSize {
+		return ctx, false
+	}
+	var mutators []tag.Mutator
+	for _, member := range strings.Split(header, ",") {
+		if len(mutators) == b.maxEntries {
+			break
+		}
+		// the properties of the member are not propagated
+		kv, _, _ := strings.Cut(member, ";")
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		key, ok := b.keys[strings.TrimSpace(k)]
+		if !ok {
+			continue
+		}
+		v, err := url.PathUnescape(strings.TrimSpace(v))
+		if err != nil || v == "" || !isValidTagValue(v) {
+			continue
+		}
+		mutators = append(mutators, tag.Upsert(key, v, tag.WithTTL(tag.TTLUnlimitedPropagation)))
+	}
+	if len(mutators) == 0 {
+		return ctx, false
+	}
+	ctx, err := tag.New(ctx, mutators...)
+	return ctx, err == nil
+}
+
+// inject sets the baggage header of the request with the allowed tags of the context
+func (b *baggage) inject(ctx context.Context, req *http.Request) {
+	m := tag.FromContext(ctx)
+	if m == nil {
+		return
+	}
+	members := make([]string, 0, len(b.order))
+	size := 0
+	for _, k := range b.order {
+		if len(members) == b.maxEntries {
+			break
+		}
+		v, ok := m.Value(k)
+		if !ok {
+			continue
+		}
+		member := k.Name() + "=" + url.PathEscape(v)
+		if size+len(member)+1 > b.maxSize {
+			break
+		}
+		size += len(member) + 1
+		members = append(members, member)
+	}
+	if len(members) > 0 {
+		req.Header.Set(baggageHeader, strings.Join(members, ","))
+	}
+}
@@ -0,0 +1,134 @@
+package opencensus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opencensus.io/tag"
+)
+
+func TestBaggage_extract(t *testing.T) {
+	b, err := newBaggage(&BaggageConfig{AllowedKeys: []string{"tenant", "region"}, MaxEntries: 2, MaxSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant, region := b.keys["tenant"], b.keys["region"]
+
+	for i, tc := range []struct {
+		header   string
+		expected map[tag.Key]string
+	}{
+		{header: ""},
+		{header: "user=1,session=abc"},
+		{
+			header:   "tenant=acme,user=1",
+			expected: map[tag.Key]string{tenant: "acme"},
+		},
+		{
+			header:   " tenant = acme%20corp ;prop=1, region=eu",
+			expected: map[tag.Key]string{tenant: "acme corp", region: "eu"},
+		},
+		{
+			header:   "tenant=acme,tenant=other,region=eu",
+			expected: map[tag.Key]string{tenant: "other"},
+		},
+		{header: "tenant=%ZZ"},
+		{header: "tenant=" + strings.Repeat("a", 64)},
+	} {
+		ctx, ok := b.extract(context.Background(), tc.header)
+		if ok != (len(tc.expected) > 0) {
+			t.Errorf("tc-%d: unexpected result %v", i, ok)
+			continue
+		}
+		m := tag.FromContext(ctx)
+		for _, k := range []tag.Key{tenant, region} {
+			v, found := "", false
+			if m != nil {
+				v, found = m.Value(k)
+			}
+			if expected, ok := tc.expected[k]; ok != found || v != expected {
+				t.Errorf("tc-%d: unexpected value for %s: %q", i, k.Name(), v)
+			}
+		}
+	}
+}
+
+func TestBaggage_inject(t *testing.T) {
+	b, err := newBaggage(&BaggageConfig{AllowedKeys: []string{"tenant", "region"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := tag.MustNewKey("other")
+	ctx, _ := tag.New(context.Background(),
+		tag.Upsert(b.keys["region"], "eu west"),
+		tag.Upsert(b.keys["tenant"], "acme"),
+		tag.Upsert(other, "secret"),
+	)
+	req := httptest.NewRequest(http.MethodGet, "http://example.com", http.NoBody)
+	b.inject(ctx, req)
+	if h := req.Header.Get(baggageHeader); h != "tenant=acme,region=eu%20west" {
+		t.Errorf("unexpected header: %q", h)
+	}
+}
+
+func TestTransport_baggage(t *testing.T) {
+	defer currentBaggage.Store(nil)
+
+	b, err := newBaggage(&BaggageConfig{AllowedKeys: []string{"tenant"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentBaggage.Store(b)
+	ctx, _ := tag.New(context.Background(), tag.Upsert(b.keys["tenant"], "acme"))
+
+	var received []string
+	s := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get(baggageHeader))
+	}))
+	defer s.Close()
+
+	for i, exclusion := range []Exclusion{{}, {Traces: true}, {Traces: true, Stats: true}} {
+		exclusion := exclusion
+		client := &http.Client{Transport: &Transport{
+			Base:       s.Client().Transport,
+			exclusions: func(_, _ string) Exclusion { return exclusion },
+		}}
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, http.NoBody)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if h := received[i]; h != "tenant=acme" {
+			t.Errorf("tc-%d: unexpected baggage header: %q", i, h)
+		}
+		if h := req.Header.Get(baggageHeader); h != "" {
+			t.Errorf("tc-%d: the original request should not be modified: %q", i, h)
+		}
+	}
+}
+
+func TestNewBaggage(t *testing.T) {
+	if b, err := newBaggage(nil); b != nil || err != nil {
+		t.Errorf("unexpected result: %v %v", b, err)
+	}
+	if _, err := newBaggage(&BaggageConfig{}); err != errBaggageWithoutKeys {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := newBaggage(&BaggageConfig{AllowedKeys: []string{strings.Repeat("a", 256)}}); err == nil {
+		t.Error("error expected for invalid keys")
+	}
+	b, err := newBaggage(&BaggageConfig{AllowedKeys: []string{"tenant", "tenant"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(baggageTagKeys(b)) != 0 {
+		t.Error("the baggage keys should not be metric tags by default")
+	}
+	if b.maxEntries != defaultBaggageMaxEntries || b.maxSize != defaultBaggageMaxSize || len(b.order) != 1 {
+		t.Errorf("unexpected baggage: %+v", b)
+	}
+}
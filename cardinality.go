@@ -0,0 +1,182 @@
+package opencensus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// CardinalityLimitConfig caps the number of distinct values recorded for every tag key of
+// every registered view
+type CardinalityLimitConfig struct {
+	// MaxValues is the number of distinct values accepted per tag key and view
+	MaxValues int `json:"max_values"`
+	// OverflowValue replaces the values seen after reaching the limit. Defaults to "overflow"
+	OverflowValue string `json:"overflow_value"`
+}
+
+const defaultOverflowValue = "overflow"
+
+var (
+	// CardinalityOverflows counts the tag values collapsed into the overflow value
+	CardinalityOverflows = stats.Int64(
+		"krakend.io/opencensus/cardinality/overflows",
+		"Number of tag values replaced by the overflow value",
+		stats.UnitDimensionless,
+	)
+
+	// KeyOverflowTag is the tag key that reached its cardinality limit
+	KeyOverflowTag = tag.MustNewKey("overflow_tag_key")
+
+	CardinalityOverflowsView = &view.View{
+		Name:        "krakend.io/opencensus/cardinality/overflows",
+		Description: "Count of tag values replaced by the overflow value, by tag key",
+		TagKeys:     []tag.Key{KeyOverflowTag},
+		Measure:     CardinalityOverflows,
+		Aggregation: view.Count(),
+	}
+
+	// ServerMeasures are the ochttp measures recorded by the router layer
+	ServerMeasures = []stats.Measure{
+		ochttp.ServerRequestCount,
+		ochttp.ServerLatency,
+		ochttp.ServerRequestBytes,
+		ochttp.ServerResponseBytes,
+	}
+	// clientMeasures are the ochttp measures recorded by the stats transport
+	clientMeasures = []stats.Measure{
+		ochttp.ClientRequestCount,
+		ochttp.ClientSentBytes,
+		ochttp.ClientReceivedBytes,
+		ochttp.ClientRoundtripLatency,
+		ochttp.ClientLatency,
+		ochttp.ClientRequestBytes,
+		ochttp.ClientResponseBytes,
+	}
+
+	currentCardinalityLimiter atomic.Pointer[cardinalityLimiter]
+)
+
+// LimitTagCardinality replaces the tag values of the context exceeding the cardinality limit
+// of the views aggregating the given measures with the overflow value. Every view keeps its own
+// set of values per tag key. It should be applied to the context right before recording the
+// measures.
+func LimitTagCardinality(ctx context.Context, ms ...stats.Measure) context.Context {
+	l := currentCardinalityLimiter.Load()
+	if l == nil {
+		return ctx
+	}
+	return l.limit(ctx, ms)
+}
+
+// limitedView is the part of a registered view tracked by the cardinality limiter
+type limitedView struct {
+	name    string
+	measure string
+	keys    []tag.Key
+}
+
+// viewKey identifies the values of a tag key in a view
+type viewKey struct {
+	view string
+	key  tag.Key
+}
+
+type cardinalityLimiter struct {
+	maxValues int
+	overflow  string
+	views     []limitedView
+	mu        *sync.Mutex
+	seen      map[viewKey]map[string]struct{}
+	reported  map[viewKey]bool
+}
+
+func newCardinalityLimiter(cfg *CardinalityLimitConfig, vs []*view.View) *cardinalityLimiter {
+	if cfg == nil || cfg.MaxValues <= 0 {
+		return nil
+	}
+	l := &cardinalityLimiter{
+		maxValues: cfg.MaxValues,
+		overflow:  cfg.OverflowValue,
+		mu:        new(sync.Mutex),
+		seen:      map[viewKey]map[string]struct{}{},
+		reported:  map[viewKey]bool{},
+	}
+	if l.overflow == "" {
+		l.overflow = defaultOverflowValue
+	}
+	for _, v := range vs {
+		if len(v.TagKeys) == 0 || v.Measure == nil {
+			continue
+		}
+		l.views = append(l.views, limitedView{name: v.Name, measure: v.Measure.Name(), keys: v.TagKeys})
+	}
+	return l
+}
+
+func (l *cardinalityLimiter) limit(ctx context.Context, ms []stats.Measure) context.Context {
+	m := tag.FromContext(ctx)
+	if m == nil {
+		return ctx
+	}
+	measures := make(map[string]struct{}, len(ms))
+	for _, measure := range ms {
+		measures[measure.Name()] = struct{}{}
+	}
+
+	overflowed := map[tag.Key]struct{}{}
+	for _, v := range l.views {
+		if _, ok := measures[v.measure]; !ok {
+			continue
+		}
+		for _, k := range v.keys {
+			value, ok := m.Value(k)
+			if !ok || l.admit(viewKey{view: v.name, key: k}, value) {
+				continue
+			}
+			overflowed[k] = struct{}{}
+		}
+	}
+	if len(overflowed) == 0 {
+		return ctx
+	}
+
+	mutators := make([]tag.Mutator, 0, len(overflowed))
+	for k := range overflowed {
+		mutators = append(mutators, tag.Upsert(k, l.overflow))
+		stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(KeyOverflowTag, k.Name())}, CardinalityOverflows.M(1))
+	}
+	if limited, err := tag.New(ctx, mutators...); err == nil {
+		return limited
+	}
+	return ctx
+}
+
+func (l *cardinalityLimiter) admit(vk viewKey, v string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	values, ok := l.seen[vk]
+	if !ok {
+		values = map[string]struct{}{}
+		l.seen[vk] = values
+	}
+	if _, ok := values[v]; ok {
+		return true
+	}
+	if len(values) < l.maxValues {
+		values[v] = struct{}{}
+		return true
+	}
+	if !l.reported[vk] {
+		l.reported[vk] = true
+		getLogger().Warning(logPrefix, fmt.Sprintf("the tag key %q of the view %q reached its limit of %d values. New values are recorded as %q", vk.key.Name(), vk.view, l.maxValues, l.overflow))
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package opencensus
+
+import (
+	"context"
+	"testing"
+
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+func TestCardinalityLimiter(t *testing.T) {
+	l := newCardinalityLimiter(
+		&CardinalityLimitConfig{MaxValues: 2},
+		[]*view.View{
+			{Name: "latency", Measure: ochttp.ServerLatency, TagKeys: []tag.Key{ochttp.Path}},
+			{Name: "count", Measure: ochttp.ServerRequestCount, TagKeys: []tag.Key{ochttp.Path, ochttp.Method}},
+			{Name: "untagged", Measure: ochttp.ServerRequestBytes},
+		},
+	)
+	if len(l.views) != 2 {
+		t.Errorf("unexpected tracked views: %v", l.views)
+	}
+
+	for i, tc := range []struct {
+		path     string
+		expected string
+	}{
+		{path: "/a", expected: "/a"},
+		{path: "/b", expected: "/b"},
+		{path: "/c", expected: "overflow"},
+		{path: "/a", expected: "/a"},
+		{path: "/d", expected: "overflow"},
+	} {
+		ctx, _ := tag.New(context.Background(), tag.Upsert(ochttp.Path, tc.path), tag.Upsert(ochttp.Method, "GET"))
+		m := tag.FromContext(l.limit(ctx, ServerMeasures))
+		if v, _ := m.Value(ochttp.Path); v != tc.expected {
+			t.Errorf("tc-%d: unexpected path %q", i, v)
+		}
+		if v, _ := m.Value(ochttp.Method); v != "GET" {
+			t.Errorf("tc-%d: unexpected method %q", i, v)
+		}
+	}
+	for _, name := range []string{"latency", "count"} {
+		if !l.reported[viewKey{view: name, key: ochttp.Path}] {
+			t.Errorf("the overflow of the view %s should be reported", name)
+		}
+	}
+	if l.reported[viewKey{view: "count", key: ochttp.Method}] {
+		t.Error("unexpected overflow of the method")
+	}
+}
+
+func TestCardinalityLimiter_perView(t *testing.T) {
+	l := newCardinalityLimiter(
+		&CardinalityLimitConfig{MaxValues: 1, OverflowValue: "other"},
+		[]*view.View{
+			{Name: "server", Measure: ochttp.ServerLatency, TagKeys: []tag.Key{ochttp.Path}},
+			{Name: "client", Measure: ochttp.ClientRoundtripLatency, TagKeys: []tag.Key{ochttp.Path}},
+		},
+	)
+
+	for i, tc := range []struct {
+		path     string
+		measure  stats.Measure
+		expected string
+	}{
+		{path: "/a", measure: ochttp.ServerLatency, expected: "/a"},
+		{path: "/b", measure: ochttp.ServerLatency, expected: "other"},
+		// the values seen by the server view do not count for the client one
+		{path: "/b", measure: ochttp.ClientRoundtripLatency, expected: "/b"},
+		{path: "/a", measure: ochttp.ClientRoundtripLatency, expected: "other"},
+		// the measures without views are never limited
+		{path: "/c", measure: ochttp.ServerRequestBytes, expected: "/c"},
+	} {
+		ctx, _ := tag.New(context.Background(), tag.Upsert(ochttp.Path, tc.path))
+		m := tag.FromContext(l.limit(ctx, []stats.Measure{tc.measure}))
+		if v, _ := m.Value(ochttp.Path); v != tc.expected {
+			t.Errorf("tc-%d: unexpected path %q", i, v)
+		}
+	}
+}
+
+func TestNewCardinalityLimiter_disabled(t *testing.T) {
+	if l := newCardinalityLimiter(nil, DefaultViews); l != nil {
+		t.Error("unexpected limiter")
+	}
+	if l := newCardinalityLimiter(&CardinalityLimitConfig{}, DefaultViews); l != nil {
+		t.Error("unexpected limiter")
+	}
+}
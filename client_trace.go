@@ -0,0 +1,244 @@
+package opencensus
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+)
+
+var (
+	ClientDNSLatency = stats.Float64(
+		"krakend.io/http/client/dns_latency",
+		"Time spent resolving the backend host",
+		stats.UnitMilliseconds,
+	)
+	ClientConnectLatency = stats.Float64(
+		"krakend.io/http/client/connect_latency",
+		"Time spent opening the connections to the backend",
+		stats.UnitMilliseconds,
+	)
+	ClientTLSLatency = stats.Float64(
+		"krakend.io/http/client/tls_latency",
+		"Time spent in the TLS handshakes with the backend",
+		stats.UnitMilliseconds,
+	)
+	ClientTimeToFirstByte = stats.Float64(
+		"krakend.io/http/client/ttfb",
+		"Time between the request is written and the first byte of the response is received",
+		stats.UnitMilliseconds,
+	)
+
+	// KeyConnReused tells if the backend request used a connection from the pool
+	KeyConnReused = tag.MustNewKey("krakend_conn_reused")
+
+	ClientDNSLatencyView = &view.View{
+		Name:        "krakend.io/http/client/dns_latency",
+		Description: "DNS resolution latency distribution by backend host",
+		TagKeys:     []tag.Key{ochttp.KeyClientHost},
+		Measure:     ClientDNSLatency,
+		Aggregation: ochttp.DefaultLatencyDistribution,
+	}
+	ClientConnectLatencyView = &view.View{
+		Name:        "krakend.io/http/client/connect_latency",
+		Description: "Connection latency distribution by backend host",
+		TagKeys:     []tag.Key{ochttp.KeyClientHost},
+		Measure:     ClientConnectLatency,
+		Aggregation: ochttp.DefaultLatencyDistribution,
+	}
+	ClientTLSLatencyView = &view.View{
+		Name:        "krakend.io/http/client/tls_latency",
+		Description: "TLS handshake latency distribution by backend host",
+		TagKeys:     []tag.Key{ochttp.KeyClientHost},
+		Measure:     ClientTLSLatency,
+		Aggregation: ochttp.DefaultLatencyDistribution,
+	}
+	ClientTimeToFirstByteView = &view.View{
+		Name:        "krakend.io/http/client/ttfb",
+		Description: "Time to first byte distribution by backend host and connection reuse",
+		TagKeys:     []tag.Key{ochttp.KeyClientHost, KeyConnReused},
+		Measure:     ClientTimeToFirstByte,
+		Aggregation: ochttp.DefaultLatencyDistribution,
+	}
+
+	// ClientTraceViews are added to the default views when the client_trace option is enabled
+	ClientTraceViews = []*view.View{
+		ClientDNSLatencyView,
+		ClientConnectLatencyView,
+		ClientTLSLatencyView,
+		ClientTimeToFirstByteView,
+	}
+
+	currentClientTrace atomic.Bool
+)
+
+// NewClientTrace returns an httptrace.ClientTrace adding the connection phases of the request
+// (pool wait, DNS, connect, TLS and time to first byte) to the span as annotations and
+// recording their latencies. The span may be nil, so only the latencies are recorded.
+func NewClientTrace(req *http.Request, span *trace.Span) *httptrace.ClientTrace {
+	return newClientTrace(req, span, true)
+}
+
+func newClientTrace(req *http.Request, span *trace.Span, recordStats bool) *httptrace.ClientTrace {
+	ct := &clientTracer{
+		ctx:         req.Context(),
+		span:        span,
+		host:        req.URL.Host,
+		now:         time.Now,
+		recordStats: recordStats,
+	}
+	return &httptrace.ClientTrace{
+		GetConn:              ct.getConn,
+		GotConn:              ct.gotConn,
+		DNSStart:             ct.dnsStart,
+		DNSDone:              ct.dnsDone,
+		ConnectStart:         ct.connectStart,
+		ConnectDone:          ct.connectDone,
+		TLSHandshakeStart:    ct.tlsHandshakeStart,
+		TLSHandshakeDone:     ct.tlsHandshakeDone,
+		WroteRequest:         ct.wroteRequest,
+		GotFirstResponseByte: ct.gotFirstResponseByte,
+	}
+}
+
+// clientTracer keeps the start of every phase. The httptrace hooks may be called from
+// different goroutines, so the state is guarded by a mutex
+type clientTracer struct {
+	ctx         context.Context
+	span        *trace.Span
+	host        string
+	now         func() time.Time
+	recordStats bool
+
+	mu             sync.Mutex
+	connRequested  time.Time
+	dnsStarted     time.Time
+	connectStarted time.Time
+	tlsStarted     time.Time
+	requestWritten time.Time
+	reused         bool
+}
+
+func (ct *clientTracer) getConn(hostPort string) {
+	ct.mu.Lock()
+	ct.connRequested = ct.now()
+	ct.mu.Unlock()
+	ct.span.Annotate([]trace.Attribute{trace.StringAttribute("httptrace.get_connection.host_port", hostPort)}, "GetConn")
+}
+
+func (ct *clientTracer) gotConn(info httptrace.GotConnInfo) {
+	ct.mu.Lock()
+	ct.reused = info.Reused
+	wait := ct.now().Sub(ct.connRequested)
+	ct.mu.Unlock()
+	ct.span.Annotate([]trace.Attribute{
+		trace.BoolAttribute("httptrace.got_connection.reused", info.Reused),
+		trace.BoolAttribute("httptrace.got_connection.was_idle", info.WasIdle),
+		trace.Int64Attribute("httptrace.got_connection.idle_time_ms", info.IdleTime.Milliseconds()),
+		trace.Int64Attribute("httptrace.got_connection.wait_ms", wait.Milliseconds()),
+	}, "GotConn")
+}
+
+func (ct *clientTracer) dnsStart(info httptrace.DNSStartInfo) {
+	ct.mu.Lock()
+	ct.dnsStarted = ct.now()
+	ct.mu.Unlock()
+	ct.span.Annotate([]trace.Attribute{trace.StringAttribute("httptrace.dns_start.host", info.Host)}, "DNSStart")
+}
+
+func (ct *clientTracer) dnsDone(info httptrace.DNSDoneInfo) {
+	ct.mu.Lock()
+	d := ct.now().Sub(ct.dnsStarted)
+	ct.mu.Unlock()
+	attrs := []trace.Attribute{trace.Int64Attribute("httptrace.dns_done.duration_ms", d.Milliseconds())}
+	if info.Err != nil {
+		attrs = append(attrs, trace.StringAttribute("httptrace.dns_done.error", info.Err.Error()))
+	}
+	ct.span.Annotate(attrs, "DNSDone")
+	ct.record(ClientDNSLatency.M(milliseconds(d)))
+}
+
+func (ct *clientTracer) connectStart(network, addr string) {
+	ct.mu.Lock()
+	ct.connectStarted = ct.now()
+	ct.mu.Unlock()
+	ct.span.Annotate([]trace.Attribute{
+		trace.StringAttribute("httptrace.connect_start.network", network),
+		trace.StringAttribute("httptrace.connect_start.addr", addr),
+	}, "ConnectStart")
+}
+
+func (ct *clientTracer) connectDone(network, addr string, err error) {
+	ct.mu.Lock()
+	d := ct.now().Sub(ct.connectStarted)
+	ct.mu.Unlock()
+	attrs := []trace.Attribute{
+		trace.StringAttribute("httptrace.connect_done.addr", addr),
+		trace.Int64Attribute("httptrace.connect_done.duration_ms", d.Milliseconds()),
+	}
+	if err != nil {
+		attrs = append(attrs, trace.StringAttribute("httptrace.connect_done.error", err.Error()))
+	}
+	ct.span.Annotate(attrs, "ConnectDone")
+	ct.record(ClientConnectLatency.M(milliseconds(d)))
+}
+
+func (ct *clientTracer) tlsHandshakeStart() {
+	ct.mu.Lock()
+	ct.tlsStarted = ct.now()
+	ct.mu.Unlock()
+	ct.span.Annotate(nil, "TLSHandshakeStart")
+}
+
+func (ct *clientTracer) tlsHandshakeDone(_ tls.ConnectionState, err error) {
+	ct.mu.Lock()
+	d := ct.now().Sub(ct.tlsStarted)
+	ct.mu.Unlock()
+	attrs := []trace.Attribute{trace.Int64Attribute("httptrace.tls_handshake_done.duration_ms", d.Milliseconds())}
+	if err != nil {
+		attrs = append(attrs, trace.StringAttribute("httptrace.tls_handshake_done.error", err.Error()))
+	}
+	ct.span.Annotate(attrs, "TLSHandshakeDone")
+	ct.record(ClientTLSLatency.M(milliseconds(d)))
+}
+
+func (ct *clientTracer) wroteRequest(info httptrace.WroteRequestInfo) {
+	ct.mu.Lock()
+	ct.requestWritten = ct.now()
+	ct.mu.Unlock()
+	var attrs []trace.Attribute
+	if info.Err != nil {
+		attrs = append(attrs, trace.StringAttribute("httptrace.wrote_request.error", info.Err.Error()))
+	}
+	ct.span.Annotate(attrs, "WroteRequest")
+}
+
+func (ct *clientTracer) gotFirstResponseByte() {
+	ct.mu.Lock()
+	d := ct.now().Sub(ct.requestWritten)
+	reused := ct.reused
+	ct.mu.Unlock()
+	ct.span.Annotate([]trace.Attribute{trace.Int64Attribute("httptrace.got_first_response_byte.ttfb_ms", d.Milliseconds())}, "GotFirstResponseByte")
+	ct.record(ClientTimeToFirstByte.M(milliseconds(d)), tag.Upsert(KeyConnReused, strconv.FormatBool(reused)))
+}
+
+func (ct *clientTracer) record(m stats.Measurement, tags ...tag.Mutator) {
+	if !ct.recordStats {
+		return
+	}
+	stats.RecordWithTags(ct.ctx, append(tags, tag.Upsert(ochttp.KeyClientHost, ct.host)), m)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,147 @@
+package opencensus
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/trace"
+)
+
+func TestNewClientTrace(t *testing.T) {
+	views := []*view.View{ClientConnectLatencyView, ClientTimeToFirstByteView}
+	if err := view.Register(views...); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(views...)
+
+	exporter := &spanRecorder{}
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer s.Close()
+	client := s.Client()
+
+	for i := 0; i < 2; i++ {
+		ctx, span := trace.StartSpan(context.Background(), "backend", trace.WithSampler(trace.AlwaysSample()))
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, http.NoBody)
+		req = req.WithContext(httptrace.WithClientTrace(ctx, NewClientTrace(req, span)))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		span.End()
+	}
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("unexpected number of spans: %d", len(exporter.spans))
+	}
+	for i, expected := range [][]string{
+		{"GetConn", "ConnectStart", "ConnectDone", "GotConn", "WroteRequest", "GotFirstResponseByte"},
+		{"GetConn", "GotConn", "WroteRequest", "GotFirstResponseByte"},
+	} {
+		annotations := exporter.spans[i].Annotations
+		if len(annotations) != len(expected) {
+			t.Errorf("span-%d: unexpected annotations: %v", i, annotations)
+			continue
+		}
+		for j, msg := range expected {
+			if annotations[j].Message != msg {
+				t.Errorf("span-%d: unexpected annotation #%d: %s", i, j, annotations[j].Message)
+			}
+		}
+	}
+
+	rows, err := view.RetrieveData(ClientTimeToFirstByteView.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Errorf("a row per connection reuse value expected: %v", rows)
+	}
+	rows, err = view.RetrieveData(ClientConnectLatencyView.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Data.(*view.DistributionData).Count != 1 {
+		t.Errorf("a single connection expected: %v", rows)
+	}
+}
+
+func TestTransport_clientTrace(t *testing.T) {
+	if err := view.Register(ClientTimeToFirstByteView); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(ClientTimeToFirstByteView)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("ok")) })
+	for i, tc := range []struct {
+		exclusion Exclusion
+		rows      int
+	}{
+		{exclusion: Exclusion{Traces: true}, rows: 1},
+		{exclusion: Exclusion{Stats: true}, rows: 0},
+		{rows: 1},
+	} {
+		s := httptest.NewServer(handler)
+		host := s.Listener.Addr().String()
+		exclusion := tc.exclusion
+		client := &http.Client{Transport: &Transport{
+			Base:        s.Client().Transport,
+			clientTrace: true,
+			exclusions:  func(_, _ string) Exclusion { return exclusion },
+		}}
+		resp, err := client.Get(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		s.Close()
+
+		rows, err := view.RetrieveData(ClientTimeToFirstByteView.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hostRows int
+		for _, row := range rows {
+			for _, tg := range row.Tags {
+				if tg.Key == ochttp.KeyClientHost && tg.Value == host {
+					hostRows++
+				}
+			}
+		}
+		if hostRows != tc.rows {
+			t.Errorf("tc-%d: unexpected rows for %s: %d", i, host, hostRows)
+		}
+	}
+}
+
+func TestComposableRegister_Views_clientTrace(t *testing.T) {
+	for i, tc := range []struct {
+		enabled  bool
+		expected int
+	}{
+		{expected: len(DefaultViews)},
+		{enabled: true, expected: len(DefaultViews) + len(ClientTraceViews)},
+	} {
+		vs, err := register.Views(Config{ClientTrace: tc.enabled}, nil)
+		if err != nil {
+			t.Errorf("tc-%d: unexpected error: %v", i, err)
+			continue
+		}
+		if len(vs) != tc.expected {
+			t.Errorf("tc-%d: unexpected number of views: %d", i, len(vs))
+		}
+	}
+}
@@ -61,6 +61,7 @@ func main() {
 	// Register stats and trace exporters to export the collected data.
 
 	exporter.Register(logger)
+	opencensus.SetLogger(logger)
 	if err := opencensus.Register(ctx, serviceConfig); err != nil {
 		log.Fatal(err)
 	}
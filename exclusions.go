@@ -0,0 +1,154 @@
+package opencensus
+
+import (
+	"fmt"
+	"path"
+	"sync/atomic"
+
+	"github.com/luraproject/lura/v2/config"
+)
+
+// Names of the instrumented layers
+const (
+	LayerRouter  = "router"
+	LayerPipe    = "pipe"
+	LayerBackend = "backend"
+)
+
+// Values of the exclude option of the exclusion rules
+const (
+	ExcludeAll    = "all"
+	ExcludeTraces = "traces"
+	ExcludeStats  = "stats"
+)
+
+// ExclusionRule skips the instrumentation of the requests matching all its conditions.
+// Empty conditions match any request.
+type ExclusionRule struct {
+	// Path is a glob pattern (as in path.Match) matched against the request path
+	Path string `json:"path"`
+	// Methods lists the excluded HTTP methods
+	Methods []string `json:"methods"`
+	// Layers lists the layers (router, pipe, backend) where the rule applies
+	Layers []string `json:"layers"`
+	// Exclude selects what to skip: traces, stats or all (the default)
+	Exclude string `json:"exclude"`
+}
+
+// Exclusion reports what to skip when instrumenting a request
+type Exclusion struct {
+	Traces bool
+	Stats  bool
+}
+
+// defaultExclusions skips the health checks of the backends. They are added to the configured
+// rules unless the disable_default_exclusions option is set
+var defaultExclusions = []ExclusionRule{
+	{Path: "/healthz", Layers: []string{LayerBackend}},
+	{Path: "/_ah/health", Layers: []string{LayerBackend}},
+}
+
+var currentExclusions atomic.Pointer[[]ExclusionRule]
+
+func newExclusions(rules []ExclusionRule, disableDefaults bool) ([]ExclusionRule, error) {
+	if err := validateExclusions(rules); err != nil {
+		return nil, err
+	}
+	if disableDefaults {
+		return rules, nil
+	}
+	return append(defaultExclusions[:len(defaultExclusions):len(defaultExclusions)], rules...), nil
+}
+
+func validateExclusions(rules []ExclusionRule) error {
+	for i, rule := range rules {
+		if _, err := path.Match(rule.Path, ""); err != nil {
+			return fmt.Errorf("exclusion rule #%d: bad path pattern %q: %w", i, rule.Path, err)
+		}
+		for _, layer := range rule.Layers {
+			switch layer {
+			case LayerRouter, LayerPipe, LayerBackend:
+			default:
+				return fmt.Errorf("exclusion rule #%d: unknown layer %q", i, layer)
+			}
+		}
+		switch rule.Exclude {
+		case "", ExcludeAll, ExcludeTraces, ExcludeStats:
+		default:
+			return fmt.Errorf("exclusion rule #%d: unknown exclude option %q", i, rule.Exclude)
+		}
+	}
+	return nil
+}
+
+// GetExclusionsForEndpoint returns the function reporting what to skip for the requests to
+// the endpoint in the router or the pipe layer, or nil if nothing can be excluded. The rules
+// of the endpoint are checked after the global ones.
+func GetExclusionsForEndpoint(cfg *config.EndpointConfig, layer string) func(method, path string) Exclusion {
+	var local []ExclusionRule
+	if extraCfg, err := parseEndpointConfig(cfg); err == nil {
+		local = extraCfg.Exclusions
+	}
+	return exclusionsFor(layer, local)
+}
+
+// GetExclusionsForBackend returns the function reporting what to skip for the requests sent
+// to the backend, or nil if nothing can be excluded. The rules of the backend are checked
+// after the global ones.
+func GetExclusionsForBackend(cfg *config.Backend) func(method, path string) Exclusion {
+	var local []ExclusionRule
+	if extraCfg, err := parseBackendConfig(cfg); err == nil {
+		local = extraCfg.Exclusions
+	}
+	return exclusionsFor(LayerBackend, local)
+}
+
+func exclusionsFor(layer string, local []ExclusionRule) func(method, path string) Exclusion {
+	global := defaultExclusions
+	if rules := currentExclusions.Load(); rules != nil {
+		global = *rules
+	}
+	if err := validateExclusions(local); err != nil {
+		getLogger().Error(logPrefix, err.Error())
+		local = nil
+	}
+
+	var rules []ExclusionRule
+	for _, rs := range [][]ExclusionRule{global, local} {
+		for _, rule := range rs {
+			if len(rule.Layers) == 0 || contains(rule.Layers, layer) {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return func(method, p string) Exclusion {
+		var ex Exclusion
+		for _, rule := range rules {
+			if !rule.matches(method, p) {
+				continue
+			}
+			switch rule.Exclude {
+			case ExcludeTraces:
+				ex.Traces = true
+			case ExcludeStats:
+				ex.Stats = true
+			default:
+				return Exclusion{Traces: true, Stats: true}
+			}
+		}
+		return ex
+	}
+}
+
+func (rule ExclusionRule) matches(method, p string) bool {
+	if rule.Path != "" {
+		if ok, _ := path.Match(rule.Path, p); !ok {
+			return false
+		}
+	}
+	return len(rule.Methods) == 0 || containsFold(rule.Methods, method)
+}
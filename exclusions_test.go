@@ -0,0 +1,125 @@
+package opencensus
+
+import (
+	"testing"
+
+	"github.com/luraproject/lura/v2/config"
+)
+
+func TestGetExclusionsForEndpoint(t *testing.T) {
+	defer currentExclusions.Store(nil)
+
+	rules, err := newExclusions([]ExclusionRule{
+		{Path: "/__health", Methods: []string{"get"}},
+		{Path: "/__stats", Layers: []string{LayerRouter}, Exclude: ExcludeStats},
+		{Path: "/internal/*", Layers: []string{LayerRouter}, Exclude: ExcludeTraces},
+		{Path: "/internal/metrics", Layers: []string{LayerRouter}, Exclude: ExcludeStats},
+		{Path: "/__debug", Layers: []string{LayerPipe}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentExclusions.Store(&rules)
+
+	cfg := &config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				"exclusions": []interface{}{
+					map[string]interface{}{"path": "/local", "exclude": "traces"},
+				},
+			},
+		},
+	}
+	exclusions := GetExclusionsForEndpoint(cfg, LayerRouter)
+	if exclusions == nil {
+		t.Fatal("nil exclusions")
+	}
+
+	for i, tc := range []struct {
+		method, path string
+		expected     Exclusion
+	}{
+		{method: "GET", path: "/__health", expected: Exclusion{Traces: true, Stats: true}},
+		{method: "POST", path: "/__health"},
+		{method: "GET", path: "/__stats", expected: Exclusion{Stats: true}},
+		{method: "GET", path: "/internal/users", expected: Exclusion{Traces: true}},
+		{method: "GET", path: "/internal/metrics", expected: Exclusion{Traces: true, Stats: true}},
+		{method: "GET", path: "/__debug"},
+		{method: "GET", path: "/local", expected: Exclusion{Traces: true}},
+		{method: "GET", path: "/users"},
+	} {
+		if ex := exclusions(tc.method, tc.path); ex != tc.expected {
+			t.Errorf("tc-%d: have %+v, want %+v", i, ex, tc.expected)
+		}
+	}
+
+	if ex := GetExclusionsForEndpoint(&config.EndpointConfig{}, LayerPipe)("GET", "/__debug"); !ex.Traces || !ex.Stats {
+		t.Errorf("the pipe rules should apply to the pipe layer: %+v", ex)
+	}
+	if GetExclusionsForBackend(&config.Backend{}) == nil {
+		t.Error("the rules without layers should apply to the backends")
+	}
+}
+
+func TestGetExclusionsForBackend_default(t *testing.T) {
+	defer currentExclusions.Store(nil)
+
+	rules, _ := newExclusions(nil, false)
+	currentExclusions.Store(&rules)
+
+	exclusions := GetExclusionsForBackend(nil)
+	if exclusions == nil {
+		t.Fatal("nil exclusions")
+	}
+	for _, p := range []string{"/healthz", "/_ah/health"} {
+		if ex := exclusions("GET", p); !ex.Traces || !ex.Stats {
+			t.Errorf("%s should be excluded by default: %+v", p, ex)
+		}
+	}
+	if ex := exclusions("GET", "/users"); ex.Traces || ex.Stats {
+		t.Errorf("unexpected exclusion: %+v", ex)
+	}
+	if GetExclusionsForEndpoint(nil, LayerRouter) != nil {
+		t.Error("the default rules should not apply to the router")
+	}
+}
+
+func TestGetExclusionsForBackend_mergedDefaults(t *testing.T) {
+	defer currentExclusions.Store(nil)
+
+	for i, tc := range []struct {
+		disableDefaults bool
+		healthExcluded  bool
+	}{
+		{healthExcluded: true},
+		{disableDefaults: true},
+	} {
+		rules, err := newExclusions([]ExclusionRule{{Path: "/internal/*", Exclude: ExcludeStats}}, tc.disableDefaults)
+		if err != nil {
+			t.Fatal(err)
+		}
+		currentExclusions.Store(&rules)
+
+		exclusions := GetExclusionsForBackend(nil)
+		if ex := exclusions("GET", "/internal/users"); ex.Traces || !ex.Stats {
+			t.Errorf("tc-%d: the configured rule should apply: %+v", i, ex)
+		}
+		for _, p := range []string{"/healthz", "/_ah/health"} {
+			if ex := exclusions("GET", p); ex.Traces != tc.healthExcluded || ex.Stats != tc.healthExcluded {
+				t.Errorf("tc-%d: unexpected exclusion of %s: %+v", i, p, ex)
+			}
+		}
+	}
+}
+
+func TestNewExclusions_invalid(t *testing.T) {
+	for i, rule := range []ExclusionRule{
+		{Path: "["},
+		{Layers: []string{"unknown"}},
+		{Exclude: "spans"},
+	} {
+		if _, err := newExclusions([]ExclusionRule{rule}, false); err == nil {
+			t.Errorf("tc-%d: error expected", i)
+		}
+	}
+}
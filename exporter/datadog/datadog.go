@@ -2,14 +2,14 @@ package datadog
 
 import (
 	"context"
-	"errors"
+	"fmt"
 
 	datadog "github.com/DataDog/opencensus-go-exporter-datadog"
 	opencensus "github.com/krakend/krakend-opencensus/v2"
 )
 
 func init() {
-	opencensus.RegisterExporterFactories(func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
+	opencensus.RegisterNamedExporterFactory("datadog", func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
 		return Exporter(ctx, cfg)
 	})
 }
@@ -39,4 +39,4 @@ func Exporter(ctx context.Context, cfg opencensus.Config) (*datadog.Exporter, er
 	return e, nil
 }
 
-var errDisabled = errors.New("opencensus datadog exporter disabled")
+var errDisabled = fmt.Errorf("opencensus datadog %w", opencensus.ErrExporterDisabled)
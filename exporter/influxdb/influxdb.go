@@ -2,7 +2,7 @@ package influxdb
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"time"
 
 	"github.com/kpacha/opencensus-influxdb"
@@ -10,7 +10,7 @@ import (
 )
 
 func init() {
-	opencensus.RegisterExporterFactories(func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
+	opencensus.RegisterNamedExporterFactory("influxdb", func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
 		return Exporter(ctx, cfg)
 	})
 }
@@ -34,4 +34,4 @@ func Exporter(ctx context.Context, cfg opencensus.Config) (*influxdb.Exporter, e
 	})
 }
 
-var errDisabled = errors.New("opencensus influxdb exporter disabled")
+var errDisabled = fmt.Errorf("opencensus influxdb %w", opencensus.ErrExporterDisabled)
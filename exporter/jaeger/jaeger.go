@@ -2,14 +2,14 @@ package jaeger
 
 import (
 	"context"
-	"errors"
+	"fmt"
 
 	"contrib.go.opencensus.io/exporter/jaeger"
 	opencensus "github.com/krakend/krakend-opencensus/v2"
 )
 
 func init() {
-	opencensus.RegisterExporterFactories(func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
+	opencensus.RegisterNamedExporterFactory("jaeger", func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
 		return Exporter(ctx, cfg)
 	})
 }
@@ -38,4 +38,4 @@ func Exporter(ctx context.Context, cfg opencensus.Config) (*jaeger.Exporter, err
 	return e, nil
 }
 
-var errDisabled = errors.New("opencensus jaeger exporter disabled")
+var errDisabled = fmt.Errorf("opencensus jaeger %w", opencensus.ErrExporterDisabled)
@@ -10,7 +10,7 @@ import (
 )
 
 func Register(l logging.Logger) {
-	opencensus.RegisterExporterFactories(func(_ context.Context, _ opencensus.Config) (interface{}, error) {
+	opencensus.RegisterNamedExporterFactory("logger", func(_ context.Context, _ opencensus.Config) (interface{}, error) {
 		return Logger{l}, nil
 	})
 }
@@ -3,6 +3,7 @@ package ocagent
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"contrib.go.opencensus.io/exporter/ocagent"
@@ -13,7 +14,7 @@ import (
 )
 
 func init() {
-	opencensus.RegisterExporterFactories(func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
+	opencensus.RegisterNamedExporterFactory("ocagent", func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
 		return Exporter(ctx, cfg)
 	})
 }
@@ -21,7 +22,7 @@ func init() {
 func Exporter(_ context.Context, cfg opencensus.Config) (*ocagent.Exporter, error) {
 	options := []ocagent.ExporterOption{}
 	if cfg.Exporters.Ocagent == nil {
-		return nil, errors.New("ocagent exporter disabled")
+		return nil, fmt.Errorf("ocagent %w", opencensus.ErrExporterDisabled)
 	}
 
 	if cfg.Exporters.Ocagent.Address == "" {
@@ -2,10 +2,11 @@ package prometheus
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"contrib.go.opencensus.io/exporter/prometheus"
@@ -14,7 +15,7 @@ import (
 )
 
 func init() {
-	opencensus.RegisterExporterFactories(func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
+	opencensus.RegisterNamedExporterFactory("prometheus", func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
 		return Exporter(ctx, cfg)
 	})
 }
@@ -41,26 +42,98 @@ func Exporter(ctx context.Context, cfg opencensus.Config) (*prometheus.Exporter,
 
 	router := http.NewServeMux()
 	router.Handle("/metrics", exporter)
-	server := http.Server{
-		Handler:           router,
-		Addr:              fmt.Sprintf(":%d", cfg.Exporters.Prometheus.Port),
-		ReadHeaderTimeout: 3 * time.Second,
+	if err := serve(ctx, fmt.Sprintf(":%d", cfg.Exporters.Prometheus.Port), router); err != nil {
+		return nil, err
 	}
 
-	go func() {
-		if serverErr := server.ListenAndServe(); serverErr != http.ErrServerClosed {
-			log.Fatalf("[SERVICE: Opencensus] The Prometheus exporter failed to listen and serve: %v", serverErr)
+	return exporter, nil
+}
+
+// metricsServer serves the metrics of the exporters sharing its address. A reload creates the
+// new exporter while the previous one is still running, so both are attached to the same
+// server, which keeps serving the oldest one until its context is canceled
+type metricsServer struct {
+	server   *http.Server
+	mu       *sync.Mutex
+	handlers []*http.Handler
+}
+
+func (s *metricsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	if len(s.handlers) == 0 {
+		s.mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	h := *s.handlers[0]
+	s.mu.Unlock()
+	h.ServeHTTP(w, r)
+}
+
+// detach removes the handler and reports if there are no handlers left
+func (s *metricsServer) detach(h *http.Handler) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, handler := range s.handlers {
+		if handler == h {
+			s.handlers = append(s.handlers[:i], s.handlers[i+1:]...)
+			break
 		}
-	}()
+	}
+	return len(s.handlers) == 0
+}
+
+// serve attaches the handler to the server listening on addr until the context is canceled,
+// starting the server if there is none. The port is bound before returning, so the errors
+// are reported to the caller
+func serve(ctx context.Context, addr string, h http.Handler) error {
+	serversMu.Lock()
+	defer serversMu.Unlock()
+
+	s, ok := servers[addr]
+	if !ok {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		s = &metricsServer{mu: new(sync.Mutex)}
+		s.server = &http.Server{
+			Handler:           s,
+			ReadHeaderTimeout: 3 * time.Second,
+		}
+		servers[addr] = s
+		go func() {
+			if serverErr := s.server.Serve(ln); serverErr != http.ErrServerClosed {
+				log.Printf("[SERVICE: Opencensus] The Prometheus exporter stopped serving: %v", serverErr)
+			}
+		}()
+	}
+
+	handler := &h
+	s.mu.Lock()
+	s.handlers = append(s.handlers, handler)
+	s.mu.Unlock()
 
 	go func() {
 		<-ctx.Done()
+		serversMu.Lock()
+		defer serversMu.Unlock()
+		if !s.detach(handler) {
+			return
+		}
+		// the port is released before the next exporter on this address tries to bind it
+		delete(servers, addr)
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		server.Shutdown(ctx)
+		s.server.Shutdown(ctx)
 		cancel()
 	}()
 
-	return exporter, nil
+	return nil
 }
 
-var errDisabled = errors.New("opencensus prometheus exporter disabled")
+var (
+	errDisabled = fmt.Errorf("opencensus prometheus %w", opencensus.ErrExporterDisabled)
+
+	serversMu = new(sync.Mutex)
+	servers   = map[string]*metricsServer{}
+)
@@ -2,7 +2,7 @@ package stackdriver
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"time"
 
 	"contrib.go.opencensus.io/exporter/stackdriver"
@@ -11,7 +11,7 @@ import (
 )
 
 func init() {
-	opencensus.RegisterExporterFactories(func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
+	opencensus.RegisterNamedExporterFactory("stackdriver", func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
 		return Exporter(ctx, cfg)
 	})
 }
@@ -20,7 +20,7 @@ var defaultMetricPrefix = "krakend"
 
 func Exporter(_ context.Context, cfg opencensus.Config) (*stackdriver.Exporter, error) {
 	if cfg.Exporters.Stackdriver == nil {
-		return nil, errors.New("stackdriver exporter disabled")
+		return nil, fmt.Errorf("stackdriver %w", opencensus.ErrExporterDisabled)
 	}
 	if cfg.Exporters.Stackdriver.MetricPrefix == "" {
 		cfg.Exporters.Stackdriver.MetricPrefix = defaultMetricPrefix
@@ -2,7 +2,7 @@ package xray
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"time"
 
 	ocAws "contrib.go.opencensus.io/exporter/aws"
@@ -14,14 +14,14 @@ import (
 )
 
 func init() {
-	opencensus.RegisterExporterFactories(func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
+	opencensus.RegisterNamedExporterFactory("xray", func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
 		return Exporter(ctx, cfg)
 	})
 }
 
 func Exporter(_ context.Context, cfg opencensus.Config) (*ocAws.Exporter, error) {
 	if cfg.Exporters.Xray == nil {
-		return nil, errors.New("xray exporter disabled")
+		return nil, fmt.Errorf("xray %w", opencensus.ErrExporterDisabled)
 	}
 	if cfg.Exporters.Xray.Version == "" {
 		cfg.Exporters.Xray.Version = "KrakenD-opencensus"
@@ -2,7 +2,7 @@ package zipkin
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"net"
 
 	"contrib.go.opencensus.io/exporter/zipkin"
@@ -12,7 +12,7 @@ import (
 )
 
 func init() {
-	opencensus.RegisterExporterFactories(func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
+	opencensus.RegisterNamedExporterFactory("zipkin", func(ctx context.Context, cfg opencensus.Config) (interface{}, error) {
 		return Exporter(ctx, cfg)
 	})
 }
@@ -31,4 +31,4 @@ func Exporter(_ context.Context, cfg opencensus.Config) (*zipkin.Exporter, error
 	), nil
 }
 
-var errDisabled = errors.New("opencensus zipkin exporter disabled")
+var errDisabled = fmt.Errorf("opencensus zipkin %w", opencensus.ErrExporterDisabled)
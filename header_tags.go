@@ -0,0 +1,120 @@
+package opencensus
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"go.opencensus.io/tag"
+)
+
+// HeaderTagConfig maps a request header to a tag of the http/server and http/client views.
+// Notice the backend requests only carry the headers listed in the input_headers of the backend.
+type HeaderTagConfig struct {
+	Header string `json:"header"`
+	TagKey string `json:"tag_key"`
+	// AllowedValues limits the accepted values of the header. Any other value is replaced by
+	// the fallback. An empty list accepts any value (use with caution!)
+	AllowedValues []string `json:"allowed_values"`
+	// Fallback is the tag value for the missing and the not allowed values. Defaults to "other"
+	Fallback string `json:"fallback"`
+}
+
+const (
+	defaultHeaderTagFallback = "other"
+	maxTagValueLength        = 255
+)
+
+var currentHeaderTags atomic.Pointer[[]headerTag]
+
+type headerTag struct {
+	header   string
+	key      tag.Key
+	allowed  map[string]string
+	fallback string
+}
+
+// HeaderTagGenerators returns the functions extracting the configured header tags from a request
+func HeaderTagGenerators() []func(*http.Request) tag.Mutator {
+	hts := currentHeaderTags.Load()
+	if hts == nil {
+		return nil
+	}
+	res := make([]func(*http.Request) tag.Mutator, len(*hts))
+	for i, ht := range *hts {
+		res[i] = ht.mutator
+	}
+	return res
+}
+
+func newHeaderTags(cfgs []HeaderTagConfig) ([]headerTag, error) {
+	hts := make([]headerTag, len(cfgs))
+	for i, cfg := range cfgs {
+		if cfg.Header == "" {
+			return nil, fmt.Errorf("header tag #%d: missing header name", i)
+		}
+		key, err := tag.NewKey(cfg.TagKey)
+		if err != nil {
+			return nil, fmt.Errorf("header tag #%d: %w", i, err)
+		}
+		ht := headerTag{
+			header:   http.CanonicalHeaderKey(cfg.Header),
+			key:      key,
+			fallback: cfg.Fallback,
+		}
+		if ht.fallback == "" {
+			ht.fallback = defaultHeaderTagFallback
+		}
+		if len(cfg.AllowedValues) > 0 {
+			ht.allowed = make(map[string]string, len(cfg.AllowedValues))
+			for _, v := range cfg.AllowedValues {
+				ht.allowed[strings.ToLower(v)] = v
+			}
+		}
+		hts[i] = ht
+	}
+	return hts, nil
+}
+
+func headerTagKeys(hts []headerTag) []tag.Key {
+	keys := make([]tag.Key, len(hts))
+	for i, ht := range hts {
+		keys[i] = ht.key
+	}
+	return keys
+}
+
+func (ht headerTag) mutator(r *http.Request) tag.Mutator {
+	return tag.Upsert(ht.key, ht.value(r.Header.Get(ht.header)))
+}
+
+func (ht headerTag) value(v string) string {
+	if v == "" {
+		return ht.fallback
+	}
+	if ht.allowed != nil {
+		if canonical, ok := ht.allowed[strings.ToLower(v)]; ok {
+			return canonical
+		}
+		return ht.fallback
+	}
+	if !isValidTagValue(v) {
+		return ht.fallback
+	}
+	return v
+}
+
+// isValidTagValue checks the restrictions of the opencensus tag values, since a single
+// invalid value discards all the tags of the request
+func isValidTagValue(v string) bool {
+	if len(v) > maxTagValueLength {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		if v[i] < ' ' || v[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
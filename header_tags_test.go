@@ -0,0 +1,87 @@
+package opencensus
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.opencensus.io/tag"
+)
+
+func TestHeaderTags(t *testing.T) {
+	hts, err := newHeaderTags([]HeaderTagConfig{
+		{
+			Header:        "x-tenant-id",
+			TagKey:        "tenant",
+			AllowedValues: []string{"ACME", "globex"},
+		},
+		{
+			Header:   "X-Client-Version",
+			TagKey:   "client_version",
+			Fallback: "unknown",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentHeaderTags.Store(&hts)
+	defer currentHeaderTags.Store(nil)
+
+	tenant, version := tag.MustNewKey("tenant"), tag.MustNewKey("client_version")
+
+	for i, tc := range []struct {
+		headers map[string]string
+		tenant  string
+		version string
+	}{
+		{
+			headers: map[string]string{"X-Tenant-Id": "acme", "X-Client-Version": "1.2.3"},
+			tenant:  "ACME",
+			version: "1.2.3",
+		},
+		{
+			headers: map[string]string{"X-Tenant-Id": "initech", "X-Client-Version": strings.Repeat("1", 256)},
+			tenant:  "other",
+			version: "unknown",
+		},
+		{
+			headers: map[string]string{"X-Client-Version": "1.2.3\n"},
+			tenant:  "other",
+			version: "unknown",
+		},
+	} {
+		r, _ := http.NewRequest("GET", "http://example.tld/", http.NoBody)
+		for k, v := range tc.headers {
+			r.Header.Set(k, v)
+		}
+		gs := HeaderTagGenerators()
+		ms := make([]tag.Mutator, len(gs))
+		for j, g := range gs {
+			ms[j] = g(r)
+		}
+		ctx, err := tag.New(context.Background(), ms...)
+		if err != nil {
+			t.Errorf("tc-%d: unexpected error: %v", i, err)
+			continue
+		}
+		m := tag.FromContext(ctx)
+		if v, _ := m.Value(tenant); v != tc.tenant {
+			t.Errorf("tc-%d: unexpected tenant %q", i, v)
+		}
+		if v, _ := m.Value(version); v != tc.version {
+			t.Errorf("tc-%d: unexpected version %q", i, v)
+		}
+	}
+}
+
+func TestNewHeaderTags_badConfig(t *testing.T) {
+	for i, cfg := range []HeaderTagConfig{
+		{TagKey: "tenant"},
+		{Header: "X-Tenant-Id"},
+	} {
+		if _, err := newHeaderTags([]HeaderTagConfig{cfg}); err == nil {
+			t.Errorf("tc-%d: error expected", i)
+		}
+	}
+}
@@ -30,6 +30,12 @@ func HTTPRequestExecutorFromConfig(clientFactory transport.HTTPClientFactory, cf
 	}
 
 	pathExtractor := GetAggregatedPathForBackendMetrics(cfg)
+	sampler := GetSamplerForBackend(cfg)
+	spanNameFormatter := GetSpanNameForBackendRequest(cfg)
+	headerTags := HeaderTagGenerators()
+	exclusions := GetExclusionsForBackend(cfg)
+	spanHeaders := GetSpanHeadersForBackend(cfg)
+	clientTrace := currentClientTrace.Load()
 
 	return func(ctx context.Context, req *http.Request) (*http.Response, error) {
 		httpClient := clientFactory(ctx)
@@ -38,14 +44,24 @@ func HTTPRequestExecutorFromConfig(clientFactory transport.HTTPClientFactory, cf
 			return httpClient.Do(req.WithContext(trace.NewContext(ctx, fromContext(ctx))))
 		}
 
+		tags := []tagGenerator{
+			func(r *http.Request) tag.Mutator { return tag.Upsert(ochttp.KeyClientHost, req.Host) },
+			func(r *http.Request) tag.Mutator { return tag.Upsert(ochttp.KeyClientPath, pathExtractor(r)) },
+			func(r *http.Request) tag.Mutator { return tag.Upsert(ochttp.KeyClientMethod, req.Method) },
+		}
+		for _, tg := range headerTags {
+			tags = append(tags, tg)
+		}
+
 		c := &http.Client{
 			Transport: &Transport{
-				Base: httpClient.Transport,
-				tags: []tagGenerator{
-					func(r *http.Request) tag.Mutator { return tag.Upsert(ochttp.KeyClientHost, req.Host) },
-					func(r *http.Request) tag.Mutator { return tag.Upsert(ochttp.KeyClientPath, pathExtractor(r)) },
-					func(r *http.Request) tag.Mutator { return tag.Upsert(ochttp.KeyClientMethod, req.Method) },
-				},
+				Base:           httpClient.Transport,
+				StartOptions:   trace.StartOptions{Sampler: sampler},
+				FormatSpanName: spanNameFormatter,
+				tags:           tags,
+				clientTrace:    clientTrace,
+				exclusions:     exclusions,
+				spanHeaders:    spanHeaders,
 			},
 			CheckRedirect: httpClient.CheckRedirect,
 			Jar:           httpClient.Jar,
@@ -27,15 +27,12 @@ import (
 	"time"
 
 	"go.opencensus.io/plugin/ochttp"
-	"go.opencensus.io/plugin/ochttp/propagation/b3"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/tag"
 	"go.opencensus.io/trace"
 	"go.opencensus.io/trace/propagation"
 )
 
-var defaultFormat propagation.HTTPFormat = &b3.HTTPFormat{}
-
 // Transport is an http.RoundTripper that instruments all outgoing requests with
 // OpenCensus stats and tracing.
 //
@@ -50,8 +47,8 @@ type Transport struct {
 	// the returned round tripper will be cancelable.
 	Base http.RoundTripper
 
-	// Propagation defines how traces are propagated. If unspecified, a default
-	// (currently B3 format) will be used.
+	// Propagation defines how traces are propagated. If unspecified, the formats
+	// defined by the propagation option (B3 by default) will be used.
 	Propagation propagation.HTTPFormat
 
 	// StartOptions are applied to the span started by this Transport around each
@@ -75,10 +72,20 @@ type Transport struct {
 	// httptrace package.
 	NewClientTrace func(*http.Request, *trace.Span) *httptrace.ClientTrace
 
-	// TODO: Implement tag propagation for HTTP.
-
 	// Tag Mutator
 	tags []tagGenerator
+
+	// exclusions reports what to skip for each request. The global rules of the backend layer
+	// are used if it is not set
+	exclusions func(method, path string) Exclusion
+
+	// spanHeaders records the allowlisted headers in the spans. The global span_headers
+	// option is used if it is not set
+	spanHeaders *SpanHeaders
+
+	// clientTrace replaces NewClientTrace with the tracer of the connection phases, recording
+	// their latencies unless the stats of the request are excluded
+	clientTrace bool
 }
 
 type tagGenerator func(*http.Request) tag.Mutator
@@ -86,13 +93,25 @@ type tagGenerator func(*http.Request) tag.Mutator
 // RoundTrip implements http.RoundTripper, delegating to Base and recording stats and traces for the request.
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	rt := t.base()
-	if isHealthEndpoint(req.URL.Path) {
+	exclusions := t.exclusions
+	if exclusions == nil {
+		exclusions = GetExclusionsForBackend(nil)
+	}
+	var ex Exclusion
+	if exclusions != nil {
+		ex = exclusions(req.Method, req.URL.Path)
+	}
+	if bg := currentBaggage.Load(); bg != nil {
+		// the baggage is propagated even if the traces of the request are excluded
+		req = withBaggage(bg, req)
+	}
+	if ex.Traces && ex.Stats {
 		return rt.RoundTrip(req)
 	}
 	// TODO: remove excessive nesting of http.RoundTrippers here.
 	format := t.Propagation
 	if format == nil {
-		format = defaultFormat
+		format = PropagationFormat()
 	}
 	spanNameFormatter := t.FormatSpanName
 	if spanNameFormatter == nil {
@@ -104,20 +123,55 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		startOpts = t.GetStartOptions(req)
 	}
 
-	rt = &traceTransport{
-		base:   rt,
-		format: format,
-		startOptions: trace.StartOptions{
-			Sampler:  startOpts.Sampler,
-			SpanKind: trace.SpanKindClient,
-		},
-		formatSpanName: spanNameFormatter,
-		newClientTrace: t.NewClientTrace,
+	clientTrace := t.NewClientTrace
+	if t.clientTrace {
+		recordStats := !ex.Stats
+		clientTrace = func(r *http.Request, span *trace.Span) *httptrace.ClientTrace {
+			return newClientTrace(r, span, recordStats)
+		}
+	}
+
+	if !ex.Traces {
+		spanHeaders := t.spanHeaders
+		if spanHeaders == nil {
+			spanHeaders = GetSpanHeadersForBackend(nil)
+		}
+		rt = &traceTransport{
+			base:   rt,
+			format: format,
+			startOptions: trace.StartOptions{
+				Sampler:  startOpts.Sampler,
+				SpanKind: trace.SpanKindClient,
+			},
+			formatSpanName: spanNameFormatter,
+			newClientTrace: clientTrace,
+			spanHeaders:    spanHeaders,
+		}
+	} else if clientTrace != nil {
+		// the connection phases are still measured without a span
+		rt = clientTraceTransport{base: rt, newClientTrace: clientTrace}
+	}
+	if !ex.Stats {
+		rt = statsTransport{base: rt, tags: t.tags}
 	}
-	rt = statsTransport{base: rt, tags: t.tags}
 	return rt.RoundTrip(req)
 }
 
+// withBaggage returns a copy of the request with the baggage header of its context, leaving
+// the original request untouched as required by the http.RoundTripper contract
+func withBaggage(bg *baggage, req *http.Request) *http.Request {
+	if tag.FromContext(req.Context()) == nil {
+		return req
+	}
+	req = req.WithContext(req.Context())
+	req.Header = req.Header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	bg.inject(req.Context(), req)
+	return req
+}
+
 func (t *Transport) base() http.RoundTripper {
 	if t.Base != nil {
 		return t.Base
@@ -141,6 +195,7 @@ type traceTransport struct {
 	format         propagation.HTTPFormat
 	formatSpanName func(*http.Request) string
 	newClientTrace func(*http.Request, *trace.Span) *httptrace.ClientTrace
+	spanHeaders    *SpanHeaders
 }
 
 // RoundTrip creates a trace.Span and inserts it into the outgoing request's headers.
@@ -171,10 +226,11 @@ func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 			header[k] = v
 		}
 		req.Header = header
-		t.format.SpanContextToRequest(span.SpanContext(), req)
+		t.format.SpanContextToRequest(propagatedSpanContext(span.SpanContext()), req)
 	}
 
 	span.AddAttributes(RequestAttrs(req)...)
+	span.AddAttributes(t.spanHeaders.RequestAttrs(req.Header)...)
 	resp, err := t.base.RoundTrip(req)
 	if err != nil {
 		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
@@ -183,6 +239,7 @@ func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 
 	span.AddAttributes(ResponseAttrs(resp)...)
+	span.AddAttributes(t.spanHeaders.ResponseAttrs(resp.Header)...)
 	span.SetStatus(TraceStatus(resp.StatusCode, resp.Status))
 
 	// span.End() will be invoked after
@@ -307,11 +364,16 @@ var codeToStr = map[int32]string{
 	trace.StatusCodeUnauthenticated:    `UNAUTHENTICATED`,
 }
 
-func isHealthEndpoint(path string) bool {
-	if path == "/healthz" || path == "/_ah/health" {
-		return true
-	}
-	return false
+// clientTraceTransport is an http.RoundTripper attaching the client trace to the requests
+// without a span
+type clientTraceTransport struct {
+	base           http.RoundTripper
+	newClientTrace func(*http.Request, *trace.Span) *httptrace.ClientTrace
+}
+
+// RoundTrip implements http.RoundTripper, delegating to base with the client trace attached.
+func (t clientTraceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), t.newClientTrace(req, nil))))
 }
 
 // statsTransport is an http.RoundTripper that collects stats for the outgoing requests.
@@ -329,6 +391,7 @@ func (t statsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 		tags[i] = tg(req)
 	}
 	ctx, _ := tag.New(req.Context(), tags...)
+	ctx = LimitTagCardinality(ctx, clientMeasures...)
 
 	req = req.WithContext(ctx)
 	track := &tracker{
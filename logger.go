@@ -0,0 +1,93 @@
+package opencensus
+
+import (
+	"context"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/luraproject/lura/v2/logging"
+)
+
+// NewTraceLogger decorates the logger so every entry with a context.Context among its
+// arguments gets the trace_id, span_id and sampled fields of the span in that context.
+// The context itself is removed from the logged values.
+func NewTraceLogger(l logging.Logger) logging.Logger {
+	return traceLogger{Logger: l, ctx: context.Background()}
+}
+
+// LoggerWithContext returns a logger adding the trace_id, span_id and sampled fields of the
+// span in the context to every entry
+func LoggerWithContext(ctx context.Context, l logging.Logger) logging.Logger {
+	if tl, ok := l.(traceLogger); ok {
+		l = tl.Logger
+	}
+	return traceLogger{Logger: l, ctx: ctx}
+}
+
+// LogDebug logs the values at the debug level with the trace found in the context
+func LogDebug(ctx context.Context, l logging.Logger, v ...interface{}) {
+	LoggerWithContext(ctx, l).Debug(v...)
+}
+
+// LogInfo logs the values at the info level with the trace found in the context
+func LogInfo(ctx context.Context, l logging.Logger, v ...interface{}) {
+	LoggerWithContext(ctx, l).Info(v...)
+}
+
+// LogWarning logs the values at the warning level with the trace found in the context
+func LogWarning(ctx context.Context, l logging.Logger, v ...interface{}) {
+	LoggerWithContext(ctx, l).Warning(v...)
+}
+
+// LogError logs the values at the error level with the trace found in the context
+func LogError(ctx context.Context, l logging.Logger, v ...interface{}) {
+	LoggerWithContext(ctx, l).Error(v...)
+}
+
+// LogCritical logs the values at the critical level with the trace found in the context
+func LogCritical(ctx context.Context, l logging.Logger, v ...interface{}) {
+	LoggerWithContext(ctx, l).Critical(v...)
+}
+
+type traceLogger struct {
+	logging.Logger
+	ctx context.Context
+}
+
+func (t traceLogger) Debug(v ...interface{})    { t.Logger.Debug(t.decorate(v)...) }
+func (t traceLogger) Info(v ...interface{})     { t.Logger.Info(t.decorate(v)...) }
+func (t traceLogger) Warning(v ...interface{})  { t.Logger.Warning(t.decorate(v)...) }
+func (t traceLogger) Error(v ...interface{})    { t.Logger.Error(t.decorate(v)...) }
+func (t traceLogger) Critical(v ...interface{}) { t.Logger.Critical(t.decorate(v)...) }
+func (t traceLogger) Fatal(v ...interface{})    { t.Logger.Fatal(t.decorate(v)...) }
+
+// decorate replaces the contexts in the values with the trace fields of the last one
+func (t traceLogger) decorate(v []interface{}) []interface{} {
+	ctx := t.ctx
+	res := make([]interface{}, 0, len(v)+1)
+	for _, i := range v {
+		if c, ok := i.(context.Context); ok {
+			ctx = c
+			continue
+		}
+		res = append(res, i)
+	}
+	if fields := traceFields(ctx); fields != "" {
+		res = append(res, fields)
+	}
+	return res
+}
+
+func traceFields(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	span := fromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	sc := span.SpanContext()
+	return "trace_id=" + hex.EncodeToString(sc.TraceID[:]) +
+		" span_id=" + hex.EncodeToString(sc.SpanID[:]) +
+		" sampled=" + strconv.FormatBool(sc.IsSampled())
+}
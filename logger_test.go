@@ -0,0 +1,55 @@
+package opencensus
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/luraproject/lura/v2/logging"
+	"go.opencensus.io/trace"
+)
+
+func TestNewTraceLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l, err := logging.NewLogger("DEBUG", buf, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, span := trace.StartSpan(context.Background(), "test", trace.WithSampler(trace.AlwaysSample()))
+	defer span.End()
+	sc := span.SpanContext()
+	fields := "trace_id=" + hex.EncodeToString(sc.TraceID[:]) + " span_id=" + hex.EncodeToString(sc.SpanID[:]) + " sampled=true"
+
+	tl := NewTraceLogger(l)
+	tl.Error(ctx, "[SERVICE: test]", "boom")
+	tl.Info("[SERVICE: test]", "no context")
+	LogWarning(context.WithValue(context.Background(), ContextKey, span), tl, "[SERVICE: test]", "from the gin context")
+	LoggerWithContext(context.Background(), l).Debug("[SERVICE: test]", "no span")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("unexpected log: %s", buf.String())
+	}
+	for i, tc := range []struct {
+		msg      string
+		withSpan bool
+	}{
+		{msg: "ERROR: [SERVICE: test] boom", withSpan: true},
+		{msg: "INFO: [SERVICE: test] no context"},
+		{msg: "WARNING: [SERVICE: test] from the gin context", withSpan: true},
+		{msg: "DEBUG: [SERVICE: test] no span"},
+	} {
+		if !strings.Contains(lines[i], tc.msg) {
+			t.Errorf("tc-%d: unexpected line %q", i, lines[i])
+		}
+		if strings.HasSuffix(lines[i], fields) != tc.withSpan {
+			t.Errorf("tc-%d: unexpected trace fields in %q", i, lines[i])
+		}
+		if strings.Contains(lines[i], "context.Background") {
+			t.Errorf("tc-%d: the context should not be logged: %q", i, lines[i])
+		}
+	}
+}
@@ -5,13 +5,16 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
 	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/trace"
@@ -21,10 +24,77 @@ type ExporterFactory func(context.Context, Config) (interface{}, error)
 
 func RegisterExporterFactories(ef ExporterFactory) {
 	mu.Lock()
-	exporterFactories = append(exporterFactories, ef)
+	exporterFactories = append(exporterFactories, namedExporterFactory{
+		name:    fmt.Sprintf("#%d", len(exporterFactories)),
+		factory: ef,
+	})
+	mu.Unlock()
+}
+
+// RegisterNamedExporterFactory registers an exporter factory under the given name, so
+// its failures can be identified
+func RegisterNamedExporterFactory(name string, ef ExporterFactory) {
+	mu.Lock()
+	exporterFactories = append(exporterFactories, namedExporterFactory{name: name, factory: ef})
 	mu.Unlock()
 }
 
+// SetLogger sets the logger used by the module to report problems not returned as errors
+func SetLogger(l logging.Logger) {
+	mu.Lock()
+	logger = l
+	mu.Unlock()
+}
+
+func getLogger() logging.Logger {
+	mu.RLock()
+	l := logger
+	mu.RUnlock()
+	return l
+}
+
+// ErrExporterDisabled is the error (or the wrapped error) returned by the exporter
+// factories when their exporter is not configured
+var ErrExporterDisabled = errors.New("exporter disabled")
+
+type namedExporterFactory struct {
+	name    string
+	factory ExporterFactory
+}
+
+// ExporterError reports the failure of a single exporter factory
+type ExporterError struct {
+	Name string
+	Err  error
+}
+
+func (e ExporterError) Error() string {
+	return fmt.Sprintf("exporter %s: %s", e.Name, e.Err.Error())
+}
+
+func (e ExporterError) Unwrap() error {
+	return e.Err
+}
+
+// ExporterErrors collects the failures of all the configured exporters
+type ExporterErrors []ExporterError
+
+func (e ExporterErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "opencensus exporters failed: " + strings.Join(msgs, "; ")
+}
+
+func (e ExporterErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
 func Register(ctx context.Context, srvCfg config.ServiceConfig, vs ...*view.View) error {
 	cfg, err := parseCfg(srvCfg)
 	if err != nil {
@@ -33,39 +103,229 @@ func Register(ctx context.Context, srvCfg config.ServiceConfig, vs ...*view.View
 
 	err = errSingletonExporterFactoriesRegister
 	registerOnce.Do(func() {
-		register.ExporterFactories(ctx, *cfg, exporterFactories)
+		registrationMu.Lock()
+		err = load(ctx, *cfg, vs)
+		registrationMu.Unlock()
+	})
 
-		err = register.Register(ctx, *cfg, vs)
-		if err != nil {
-			return
+	return err
+}
+
+// Reload applies the telemetry configuration defined in srvCfg (sampler, reporting period,
+// enabled layers, views and exporters) and then stops and unregisters the exporters and views
+// set up by a previous call to Register or Reload. The new setup is built and validated before
+// touching the running one, so if anything fails the previous configuration keeps working.
+//
+// These options are read on every request, so they apply to the handlers, proxies and
+// clients already created: sample_rate, sampler, sampling_rules, debug_header, propagation,
+// baggage, trusted_parents, cardinality_limit and tail_sampling, besides the views, the
+// exporters and the reporting period.
+//
+// These ones are captured when the handlers, proxies and clients are built, so they need a
+// rebuild of the router and the proxy stack to change: enabled_layers, span_names, exclusions,
+// header_tags, public_endpoints, trace_response, span_headers and client_trace, along with all
+// the options of the endpoints and backends. Until the rebuild, the views may declare header
+// tags the old handlers never set.
+func Reload(ctx context.Context, srvCfg config.ServiceConfig, vs ...*view.View) error {
+	cfg, err := parseCfg(srvCfg)
+	if err != nil {
+		return err
+	}
+
+	// any later call to Register must not add a second set of exporters
+	registerOnce.Do(func() {})
+
+	registrationMu.Lock()
+	defer registrationMu.Unlock()
+
+	return load(ctx, *cfg, vs)
+}
+
+// Unregister stops and unregisters the exporters and the views set up by the last call
+// to Register or Reload. The sampler and the enabled layers are left untouched.
+func Unregister() {
+	registrationMu.Lock()
+	unregister()
+	registrationMu.Unlock()
+}
+
+// registration keeps track of everything registered by a single load, so it can be
+// removed when the configuration is reloaded
+type registration struct {
+	cancel         context.CancelFunc
+	viewExporters  []view.Exporter
+	traceExporters []trace.Exporter
+	views          []*view.View
+}
+
+// load builds the whole setup described by cfg and, only if every step succeeds, swaps it
+// with the current one. It must be called holding the registrationMu.
+func load(ctx context.Context, cfg Config, vs []*view.View) error {
+	rs, err := newRuleSampler(cfg.SamplingRules)
+	if err != nil {
+		return err
+	}
+	dh, err := newDebugHeader(cfg.DebugHeader)
+	if err != nil {
+		return err
+	}
+	hts, err := newHeaderTags(cfg.HeaderTags)
+	if err != nil {
+		return err
+	}
+	prop, err := newPropagation(cfg.Propagation)
+	if err != nil {
+		return err
+	}
+	bg, err := newBaggage(cfg.Baggage)
+	if err != nil {
+		return err
+	}
+	pt, err := newParentTrust(cfg.PublicEndpoints, cfg.TrustedParents)
+	if err != nil {
+		return err
+	}
+	exclusions, err := newExclusions(cfg.Exclusions, cfg.DisableDefaultExclusions)
+	if err != nil {
+		return err
+	}
+	if err := validateSampler(cfg.Sampler); err != nil {
+		return err
+	}
+	if err := validateTailSampling(cfg.TailSampling); err != nil {
+		return err
+	}
+	if err := validateSpanHeaders(cfg.SpanHeaders); err != nil {
+		return err
+	}
+	views, err := register.Views(cfg, vs)
+	if err != nil {
+		return err
+	}
+
+	mu.RLock()
+	fs := exporterFactories
+	mu.RUnlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	reg := &registration{cancel: cancel, views: views}
+
+	var errs ExporterErrors
+	reg.viewExporters, reg.traceExporters, errs = register.ExporterFactories(ctx, cfg, fs)
+	if len(errs) > 0 {
+		if cfg.StrictExporters {
+			cancel()
+			return errs
 		}
+		l := getLogger()
+		for _, err := range errs {
+			l.Error(logPrefix, err.Error())
+		}
+	}
 
-		if cfg.EnabledLayers != nil {
-			enabledLayers = *cfg.EnabledLayers
-			return
+	// the views share their names with the previous ones, so they have to be swapped
+	if current != nil {
+		register.unregisterViews(current.views...)
+	}
+	if err := register.registerViews(views...); err != nil {
+		register.unregisterViews(views...)
+		if current != nil {
+			if rerr := register.registerViews(current.views...); rerr != nil {
+				getLogger().Error(logPrefix, "restoring the previous views:", rerr.Error())
+			}
 		}
+		cancel()
+		return err
+	}
 
-		enabledLayers = EnabledLayers{true, true, true}
-	})
+	if current != nil {
+		register.unregisterViewExporter(current.viewExporters...)
+		register.unregisterTraceExporter(current.traceExporters...)
+		current.cancel()
+	}
+	register.viewExporter(reg.viewExporters...)
+	register.traceExporter(reg.traceExporters...)
+	current = reg
+
+	// the tail sampler only wraps the trace exporters, so without them the head sampling applies
+	tailSampling := cfg.TailSampling != nil && len(reg.traceExporters) > 0
+	if cfg.TailSampling != nil && !tailSampling {
+		getLogger().Warning(logPrefix, "tail sampling ignored: there are no trace exporters")
+	}
+	if tailSampling {
+		currentHeadDecisions.Store(newHeadDecisions(cfg.TailSampling.MaxTraces))
+	} else {
+		currentHeadDecisions.Store(nil)
+	}
+	tailSamplingEnabled.Store(tailSampling)
 
-	return err
-}
+	sampleRate := cfg.SampleRate
+	if tailSampling {
+		// every span must be recorded so the tail sampler can take its decision
+		sampleRate = 100
+	}
+	register.setDefaultSampler(sampleRate, cfg.Sampler)
+	register.setReportingPeriod(time.Duration(cfg.ReportingPeriod) * time.Second)
 
-type composableRegister struct {
-	viewExporter       func(exporters ...view.Exporter)
-	traceExporter      func(exporters ...trace.Exporter)
-	registerViews      func(views ...*view.View) error
-	setDefaultSampler  func(rate int)
-	setReportingPeriod func(d time.Duration)
+	currentCardinalityLimiter.Store(newCardinalityLimiter(cfg.CardinalityLimit, views))
+
+	layers := EnabledLayers{true, true, true}
+	if cfg.EnabledLayers != nil {
+		layers = *cfg.EnabledLayers
+	}
+	enabledLayers.Store(&layers)
+	currentRuleSampler.Store(rs)
+	currentDebugHeader.Store(dh)
+	currentSpanNames.Store(cfg.SpanNames)
+	currentHeaderTags.Store(&hts)
+	currentPropagation.Store(&prop)
+	currentBaggage.Store(bg)
+	currentTraceResponse.Store(newTraceResponse(cfg.TraceResponse))
+	currentParentTrust.Store(pt)
+	currentExclusions.Store(&exclusions)
+	currentSpanHeaders.Store(cfg.SpanHeaders)
+	currentClientTrace.Store(cfg.ClientTrace)
+
+	return nil
+}
+
+func unregister() {
+	if current == nil {
+		return
+	}
+	// unregistering the views flushes their pending data to the exporters, so they go first
+	register.unregisterViews(current.views...)
+	register.unregisterViewExporter(current.viewExporters...)
+	register.unregisterTraceExporter(current.traceExporters...)
+	current.cancel()
+	current = nil
 }
 
-func (c *composableRegister) ExporterFactories(ctx context.Context, cfg Config, fs []ExporterFactory) {
+type composableRegister struct {
+	viewExporter            func(exporters ...view.Exporter)
+	traceExporter           func(exporters ...trace.Exporter)
+	registerViews           func(views ...*view.View) error
+	unregisterViewExporter  func(exporters ...view.Exporter)
+	unregisterTraceExporter func(exporters ...trace.Exporter)
+	unregisterViews         func(views ...*view.View)
+	setDefaultSampler       func(rate int, cfg *SamplerConfig)
+	setReportingPeriod      func(d time.Duration)
+}
+
+// ExporterFactories creates the exporters returned by the factories, ignoring the ones
+// disabled by the configuration and collecting the failures of the rest. The exporters
+// are not registered.
+func (c *composableRegister) ExporterFactories(ctx context.Context, cfg Config, fs []namedExporterFactory) ([]view.Exporter, []trace.Exporter, ExporterErrors) {
 	viewExporters := []view.Exporter{}
 	traceExporters := []trace.Exporter{}
+	var errs ExporterErrors
 
 	for _, f := range fs {
-		e, err := f(ctx, cfg)
+		e, err := f.factory(ctx, cfg)
 		if err != nil {
+			if !errors.Is(err, ErrExporterDisabled) {
+				errs = append(errs, ExporterError{Name: f.name, Err: err})
+			}
 			continue
 		}
 		if ve, ok := e.(view.Exporter); ok {
@@ -76,82 +336,195 @@ func (c *composableRegister) ExporterFactories(ctx context.Context, cfg Config,
 		}
 	}
 
-	c.viewExporter(viewExporters...)
-	c.traceExporter(traceExporters...)
+	if cfg.TailSampling != nil && len(traceExporters) > 0 {
+		traceExporters = []trace.Exporter{newTailSampler(ctx, *cfg.TailSampling, traceExporters)}
+	}
+
+	return viewExporters, traceExporters, errs
 }
 
-func (c composableRegister) Register(_ context.Context, cfg Config, vs []*view.View) error {
+// Views returns copies of the views to register, with the configured overrides and the
+// metric tags applied. The views are not registered.
+func (composableRegister) Views(cfg Config, vs []*view.View) ([]*view.View, error) {
 	if len(vs) == 0 {
 		vs = DefaultViews
+		if cfg.ClientTrace {
+			vs = append(vs[:len(vs):len(vs)], ClientTraceViews...)
+		}
+	}
+	// work on copies, so the tag keys added here do not leak into the next reload
+	vs, err := applyViewsConfig(cloneViews(vs), cfg.Views, cfg.DisabledViews)
+	if err != nil {
+		return nil, err
 	}
-
-	c.setDefaultSampler(cfg.SampleRate)
-	c.setReportingPeriod(time.Duration(cfg.ReportingPeriod) * time.Second)
 
 	// modify metric tags
 	// ref: https://godoc.org/go.opencensus.io/plugin/ochttp#pkg-variables
-	if cfg.Exporters.Prometheus != nil {
-		for _, view := range vs {
-			// client metrics (method + statuscode tags are enabled by default)
-			if strings.Contains(view.Name, "http/client") {
-				// Host
-				if cfg.Exporters.Prometheus.HostTag {
-					view.TagKeys = appendIfMissing(view.TagKeys, ochttp.KeyClientHost)
-				}
-
-				// Path
-				if cfg.Exporters.Prometheus.PathTag {
-					view.TagKeys = appendIfMissing(view.TagKeys, ochttp.KeyClientPath)
-				}
-
-				// Method
-				if cfg.Exporters.Prometheus.MethodTag {
-					view.TagKeys = appendIfMissing(view.TagKeys, ochttp.KeyClientMethod)
-				}
-
-				// StatusCode
-				if cfg.Exporters.Prometheus.StatusCodeTag {
-					view.TagKeys = appendIfMissing(view.TagKeys, ochttp.KeyClientStatus)
-				}
+	tags := cfg.metricTags()
+	hts, err := newHeaderTags(cfg.HeaderTags)
+	if err != nil {
+		return nil, err
+	}
+	bg, err := newBaggage(cfg.Baggage)
+	if err != nil {
+		return nil, err
+	}
+	headerKeys := append(headerTagKeys(hts), baggageTagKeys(bg)...)
+	for _, view := range vs {
+		// client metrics (method + statuscode tags are enabled by default)
+		if strings.Contains(view.Name, "http/client") {
+			// Host
+			if tags.HostTag {
+				view.TagKeys = appendIfMissing(view.TagKeys, ochttp.KeyClientHost)
+			}
+
+			// Path
+			if tags.PathTag {
+				view.TagKeys = appendIfMissing(view.TagKeys, ochttp.KeyClientPath)
+			}
+
+			// Method
+			if tags.MethodTag {
+				view.TagKeys = appendIfMissing(view.TagKeys, ochttp.KeyClientMethod)
+			}
+
+			// StatusCode
+			if tags.StatusCodeTag {
+				view.TagKeys = appendIfMissing(view.TagKeys, ochttp.KeyClientStatus)
+			}
+
+			// Headers and baggage
+			for _, k := range headerKeys {
+				view.TagKeys = appendIfMissing(view.TagKeys, k)
+			}
+		}
+
+		// server metrics
+		if strings.Contains(view.Name, "http/server") {
+			// Host
+			if tags.HostTag {
+				view.TagKeys = appendIfMissing(view.TagKeys, ochttp.Host)
+			}
+
+			// Path
+			if tags.PathTag {
+				view.TagKeys = appendIfMissing(view.TagKeys, ochttp.Path)
+			}
+
+			// Method
+			if tags.MethodTag {
+				view.TagKeys = appendIfMissing(view.TagKeys, ochttp.Method)
+			}
+
+			// StatusCode
+			if tags.StatusCodeTag {
+				view.TagKeys = appendIfMissing(view.TagKeys, ochttp.StatusCode)
 			}
 
-			// server metrics
-			if strings.Contains(view.Name, "http/server") {
-				// Host
-				if cfg.Exporters.Prometheus.HostTag {
-					view.TagKeys = appendIfMissing(view.TagKeys, ochttp.Host)
-				}
-
-				// Path
-				if cfg.Exporters.Prometheus.PathTag {
-					view.TagKeys = appendIfMissing(view.TagKeys, ochttp.Path)
-				}
-
-				// Method
-				if cfg.Exporters.Prometheus.MethodTag {
-					view.TagKeys = appendIfMissing(view.TagKeys, ochttp.Method)
-				}
-
-				// StatusCode
-				if cfg.Exporters.Prometheus.StatusCodeTag {
-					view.TagKeys = appendIfMissing(view.TagKeys, ochttp.StatusCode)
-				}
+			// Headers and baggage
+			for _, k := range headerKeys {
+				view.TagKeys = appendIfMissing(view.TagKeys, k)
 			}
 		}
 	}
 
-	return c.registerViews(vs...)
+	return vs, nil
 }
 
 type Config struct {
 	SampleRate      int            `json:"sample_rate"`
+	Sampler         *SamplerConfig `json:"sampler"`
 	ReportingPeriod int            `json:"reporting_period"`
 	EnabledLayers   *EnabledLayers `json:"enabled_layers"`
 	Exporters       Exporters      `json:"exporters"`
+	// StrictExporters makes the registration fail if any configured exporter cannot start
+	StrictExporters bool `json:"strict_exporters"`
+	// SamplingRules is an ordered list of rules overriding the sample rate for the
+	// requests they match. The first matching rule wins.
+	SamplingRules []SamplingRule `json:"sampling_rules"`
+	// ClientTrace annotates the backend spans with the connection phases of the requests and
+	// records their latencies (DNS, connect, TLS and time to first byte)
+	ClientTrace bool `json:"client_trace"`
+	// TailSampling enables the tail sampler, replacing the head sampling defined by sample_rate
+	TailSampling *TailSamplingConfig `json:"tail_sampling"`
+	// DebugHeader allows trusted clients to force the sampling of a request
+	DebugHeader *DebugHeaderConfig `json:"debug_header"`
+	// SpanNames defines the span name templates of every layer
+	SpanNames *SpanNamesConfig `json:"span_names"`
+	// Metrics defines the tags added to the http views, for every view exporter
+	Metrics *MetricsConfig `json:"metrics"`
+	// Views declares views to register on top of (or replacing) the default ones
+	Views []ViewConfig `json:"views"`
+	// DisabledViews lists the names of the default views to skip
+	DisabledViews []string `json:"disabled_views"`
+	// HeaderTags adds tags extracted from the request headers to the http views
+	HeaderTags []HeaderTagConfig `json:"header_tags"`
+	// CardinalityLimit caps the distinct values recorded per tag key
+	CardinalityLimit *CardinalityLimitConfig `json:"cardinality_limit"`
+	// Propagation is the ordered list of trace propagation formats (w3c, b3, b3_single, jaeger,
+	// aws, gcp). The span context is extracted from the first format found in the request and
+	// injected using all of them. Defaults to b3
+	Propagation []string `json:"propagation"`
+	// Baggage enables the propagation of the allowed entries of the W3C baggage header
+	Baggage *BaggageConfig `json:"baggage"`
+	// TraceResponse writes the trace id of every request into the response headers
+	TraceResponse *TraceResponseConfig `json:"trace_response"`
+	// PublicEndpoints makes every endpoint link the remote parents of the untrusted requests
+	// instead of continuing them
+	PublicEndpoints bool `json:"public_endpoints"`
+	// TrustedParents defines the requests allowed to continue a remote trace in the public endpoints
+	TrustedParents *TrustedParentsConfig `json:"trusted_parents"`
+	// Exclusions lists the requests to skip when instrumenting the layers, on top of the
+	// health checks of the backends
+	Exclusions []ExclusionRule `json:"exclusions"`
+	// DisableDefaultExclusions stops skipping the health checks of the backends
+	DisableDefaultExclusions bool `json:"disable_default_exclusions"`
+	// SpanHeaders lists the headers recorded as attributes of the router and the client spans
+	SpanHeaders *SpanHeadersConfig `json:"span_headers"`
+}
+
+// MetricsConfig selects the optional tags of the http/client and http/server views
+type MetricsConfig struct {
+	HostTag       bool `json:"tag_host"`
+	PathTag       bool `json:"tag_path"`
+	MethodTag     bool `json:"tag_method"`
+	StatusCodeTag bool `json:"tag_statuscode"`
+}
+
+// metricTags merges the metrics section with the tag options of the Prometheus exporter,
+// kept for backward compatibility
+func (c Config) metricTags() MetricsConfig {
+	tags := MetricsConfig{}
+	if c.Metrics != nil {
+		tags = *c.Metrics
+	}
+	if p := c.Exporters.Prometheus; p != nil {
+		tags.HostTag = tags.HostTag || p.HostTag
+		tags.PathTag = tags.PathTag || p.PathTag
+		tags.MethodTag = tags.MethodTag || p.MethodTag
+		tags.StatusCodeTag = tags.StatusCodeTag || p.StatusCodeTag
+	}
+	return tags
 }
 
 type EndpointExtraConfig struct {
 	PathAggregation string `json:"path_aggregation"`
+	// SampleRate overrides the global sample rate for the spans started by the endpoint or the backend
+	SampleRate *int `json:"sample_rate"`
+	// SpanNames overrides the global span name templates
+	SpanNames *SpanNamesConfig `json:"span_names"`
+	// PathRules are the rewrite rules applied in order by the rules path aggregation mode
+	PathRules []PathRewriteRule `json:"path_rules"`
+	// QueryParams lists the query parameters appended to the aggregated path and the span names
+	QueryParams []QueryParamConfig `json:"query_params"`
+	// DisableTraceResponse skips the trace response headers for the endpoint
+	DisableTraceResponse bool `json:"disable_trace_response"`
+	// PublicEndpoint overrides the global public_endpoints option
+	PublicEndpoint *bool `json:"public_endpoint"`
+	// Exclusions lists the requests to skip on top of the global exclusion rules
+	Exclusions []ExclusionRule `json:"exclusions"`
+	// SpanHeaders overrides the global span_headers option
+	SpanHeaders *SpanHeadersConfig `json:"span_headers"`
 }
 
 type Exporters struct {
@@ -193,12 +566,13 @@ type JaegerConfig struct {
 }
 
 type PrometheusConfig struct {
-	Namespace     string `json:"namespace"`
-	Port          int    `json:"port"`
-	HostTag       bool   `json:"tag_host"`
-	PathTag       bool   `json:"tag_path"`
-	MethodTag     bool   `json:"tag_method"`
-	StatusCodeTag bool   `json:"tag_statuscode"`
+	Namespace string `json:"namespace"`
+	Port      int    `json:"port"`
+	// the tag options are aliases of the metrics section, kept for backward compatibility
+	HostTag       bool `json:"tag_host"`
+	PathTag       bool `json:"tag_path"`
+	MethodTag     bool `json:"tag_method"`
+	StatusCodeTag bool `json:"tag_statuscode"`
 }
 
 type XrayConfig struct {
@@ -237,6 +611,8 @@ type DataDogConfig struct {
 const (
 	ContextKey = "opencensus-request-span"
 	Namespace  = "github_com/devopsfaith/krakend-opencensus"
+
+	logPrefix = "[SERVICE: Opencensus]"
 )
 
 var (
@@ -252,21 +628,39 @@ var (
 		ochttp.ServerLatencyView,
 		ochttp.ServerRequestCountByMethod,
 		ochttp.ServerResponseCountByStatusCode,
+
+		PipeLatencyView,
+		PipeErrorsView,
+		PipeCanceledView,
+		PipeIncompleteView,
+		BackendLatencyView,
+		BackendErrorsView,
+		BackendCanceledView,
+		BackendIncompleteView,
+
+		SamplerDecisionsView,
+		CardinalityOverflowsView,
 	}
 
-	exporterFactories                     = []ExporterFactory{}
+	exporterFactories                     = []namedExporterFactory{}
 	ErrNoConfig                           = errors.New("no extra config defined for the opencensus module")
 	errSingletonExporterFactoriesRegister = errors.New("expecting only one exporter factory registration per instance")
 	mu                                    = new(sync.RWMutex)
 	register                              = composableRegister{
-		viewExporter:       registerViewExporter,
-		traceExporter:      registerTraceExporter,
-		setDefaultSampler:  setDefaultSampler,
-		setReportingPeriod: setReportingPeriod,
-		registerViews:      registerViews,
-	}
-	registerOnce  = new(sync.Once)
-	enabledLayers EnabledLayers
+		viewExporter:            registerViewExporter,
+		traceExporter:           registerTraceExporter,
+		unregisterViewExporter:  unregisterViewExporter,
+		unregisterTraceExporter: unregisterTraceExporter,
+		setDefaultSampler:       setDefaultSampler,
+		setReportingPeriod:      setReportingPeriod,
+		registerViews:           registerViews,
+		unregisterViews:         unregisterViews,
+	}
+	registerOnce   = new(sync.Once)
+	registrationMu = new(sync.Mutex)
+	current        *registration
+	enabledLayers  atomic.Pointer[EnabledLayers]
+	logger         logging.Logger = logging.NoOp
 )
 
 type EnabledLayers struct {
@@ -276,15 +670,22 @@ type EnabledLayers struct {
 }
 
 func IsRouterEnabled() bool {
-	return enabledLayers.Router
+	return currentLayers().Router
 }
 
 func IsPipeEnabled() bool {
-	return enabledLayers.Pipe
+	return currentLayers().Pipe
 }
 
 func IsBackendEnabled() bool {
-	return enabledLayers.Backend
+	return currentLayers().Backend
+}
+
+func currentLayers() EnabledLayers {
+	if l := enabledLayers.Load(); l != nil {
+		return *l
+	}
+	return EnabledLayers{}
 }
 
 func parseCfg(srvCfg config.ServiceConfig) (*Config, error) {
@@ -342,6 +743,14 @@ var (
 
 // GetAggregatedPathForMetrics returns a path aggregator function ready to reduce path cardinality in the metrics
 func GetAggregatedPathForMetrics(cfg *config.EndpointConfig) func(r *http.Request) string {
+	extractor := aggregatedPathForEndpoint(cfg)
+	if extraCfg, err := parseEndpointConfig(cfg); err == nil {
+		return withQueryParams(extractor, extraCfg.QueryParams)
+	}
+	return extractor
+}
+
+func aggregatedPathForEndpoint(cfg *config.EndpointConfig) func(r *http.Request) string {
 	if cfg == nil {
 		return simplePathExtractor
 	}
@@ -365,6 +774,10 @@ func GetAggregatedPathForMetrics(cfg *config.EndpointConfig) func(r *http.Reques
 		}
 	}
 
+	if extractor := rewritePathExtractor(aggregationMode, endpointExtraCfg); extractor != nil {
+		return extractor
+	}
+
 	if aggregationMode == aggregationModePOff {
 		// no aggregration (use with caution!)
 		return simplePathExtractor
@@ -376,6 +789,14 @@ func GetAggregatedPathForMetrics(cfg *config.EndpointConfig) func(r *http.Reques
 
 // GetAggregatedPathForBackendMetrics returns a path aggregator function ready to reduce path cardinality in the metrics
 func GetAggregatedPathForBackendMetrics(cfg *config.Backend) func(r *http.Request) string {
+	extractor := aggregatedPathForBackend(cfg)
+	if extraCfg, err := parseBackendConfig(cfg); err == nil {
+		return withQueryParams(extractor, extraCfg.QueryParams)
+	}
+	return extractor
+}
+
+func aggregatedPathForBackend(cfg *config.Backend) func(r *http.Request) string {
 	if cfg == nil {
 		return simplePathExtractor
 	}
@@ -399,6 +820,10 @@ func GetAggregatedPathForBackendMetrics(cfg *config.Backend) func(r *http.Reques
 		}
 	}
 
+	if extractor := rewritePathExtractor(aggregationMode, endpointExtraCfg); extractor != nil {
+		return extractor
+	}
+
 	if aggregationMode == aggregationModePOff {
 		// no aggregration (use with caution!)
 		return simplePathExtractor
@@ -408,6 +833,26 @@ func GetAggregatedPathForBackendMetrics(cfg *config.Backend) func(r *http.Reques
 	return fixedPathExtractor(replaceMetricBackendPath.ReplaceAllString(cfg.URLPattern, `{$1}`))
 }
 
+// GetSamplerForEndpoint returns the sampler to use for the spans of the endpoint, or nil if
+// the endpoint does not override the global sample rate
+func GetSamplerForEndpoint(cfg *config.EndpointConfig) trace.Sampler {
+	extraCfg, err := parseEndpointConfig(cfg)
+	if err != nil || extraCfg.SampleRate == nil {
+		return nil
+	}
+	return samplerFromRate(*extraCfg.SampleRate)
+}
+
+// GetSamplerForBackend returns the sampler to use for the spans of the backend, or nil if
+// the backend does not override the global sample rate
+func GetSamplerForBackend(cfg *config.Backend) trace.Sampler {
+	extraCfg, err := parseBackendConfig(cfg)
+	if err != nil || extraCfg.SampleRate == nil {
+		return nil
+	}
+	return samplerFromRate(*extraCfg.SampleRate)
+}
+
 func simplePathExtractor(r *http.Request) string { return r.URL.Path }
 
 func fixedPathExtractor(path string) func(r *http.Request) string {
@@ -435,17 +880,38 @@ func registerTraceExporter(exporters ...trace.Exporter) {
 	}
 }
 
-func setDefaultSampler(rate int) {
-	var sampler trace.Sampler
+func unregisterViewExporter(exporters ...view.Exporter) {
+	for _, e := range exporters {
+		view.UnregisterExporter(e)
+	}
+}
+
+func unregisterTraceExporter(exporters ...trace.Exporter) {
+	for _, e := range exporters {
+		trace.UnregisterExporter(e)
+	}
+}
+
+func setDefaultSampler(rate int, cfg *SamplerConfig) {
+	currentRateLimiter.Store(newRateLimiter(cfg))
+	trace.ApplyConfig(trace.Config{DefaultSampler: samplerFromRate(rate)})
+}
+
+// samplerFromRate returns a probability sampler for the given percentage, capped by the
+// rate limiting sampler when it is enabled
+func samplerFromRate(rate int) trace.Sampler {
+	return rateLimited(probabilitySamplerFromRate(rate))
+}
+
+func probabilitySamplerFromRate(rate int) trace.Sampler {
 	switch {
 	case rate <= 0:
-		sampler = trace.NeverSample()
+		return trace.NeverSample()
 	case rate >= 100:
-		sampler = trace.AlwaysSample()
+		return trace.AlwaysSample()
 	default:
-		sampler = trace.ProbabilitySampler(float64(rate) / 100.0)
+		return trace.ProbabilitySampler(float64(rate) / 100.0)
 	}
-	trace.ApplyConfig(trace.Config{DefaultSampler: sampler})
 }
 
 func setReportingPeriod(d time.Duration) {
@@ -456,10 +922,16 @@ func registerViews(views ...*view.View) error {
 	return view.Register(views...)
 }
 
+func unregisterViews(views ...*view.View) {
+	view.Unregister(views...)
+}
+
 const (
 	aggregationModePattern   = "pattern"
 	aggregationModeLastParam = "lastparam"
 	aggregationModePOff      = "off"
+	aggregationModeRules     = "rules"
+	aggregationModeAuto      = "auto"
 
 	endpointPrefix = ':'
 	backendPrefix  = "{{."
@@ -1,10 +1,18 @@
 package opencensus
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/luraproject/lura/v2/config"
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/trace"
 )
 
 func TestGetAggregatedPathForMetrics(t *testing.T) {
@@ -102,3 +110,305 @@ func TestGetAggregatedPathForBackendMetrics(t *testing.T) {
 		}
 	}
 }
+
+func TestReload(t *testing.T) {
+	defer func(r composableRegister, fs []namedExporterFactory, once *sync.Once) {
+		register = r
+		mu.Lock()
+		exporterFactories = fs
+		mu.Unlock()
+		registerOnce = once
+	}(register, exporterFactories, registerOnce)
+	registerOnce = new(sync.Once)
+
+	var (
+		sampleRates       []int
+		viewExporters     = map[view.Exporter]struct{}{}
+		traceExporters    = map[trace.Exporter]struct{}{}
+		registeredViews   = map[string]*view.View{}
+		factoryContexts   []context.Context
+		unregisteredViews int
+	)
+	register = composableRegister{
+		viewExporter: func(es ...view.Exporter) {
+			for _, e := range es {
+				viewExporters[e] = struct{}{}
+			}
+		},
+		traceExporter: func(es ...trace.Exporter) {
+			for _, e := range es {
+				traceExporters[e] = struct{}{}
+			}
+		},
+		unregisterViewExporter: func(es ...view.Exporter) {
+			for _, e := range es {
+				delete(viewExporters, e)
+			}
+		},
+		unregisterTraceExporter: func(es ...trace.Exporter) {
+			for _, e := range es {
+				delete(traceExporters, e)
+			}
+		},
+		registerViews: func(vs ...*view.View) error {
+			for _, v := range vs {
+				registeredViews[v.Name] = v
+			}
+			return nil
+		},
+		unregisterViews: func(vs ...*view.View) {
+			for _, v := range vs {
+				delete(registeredViews, v.Name)
+				unregisteredViews++
+			}
+		},
+		setDefaultSampler:  func(rate int, _ *SamplerConfig) { sampleRates = append(sampleRates, rate) },
+		setReportingPeriod: func(_ time.Duration) {},
+	}
+
+	RegisterExporterFactories(func(ctx context.Context, cfg Config) (interface{}, error) {
+		factoryContexts = append(factoryContexts, ctx)
+		if cfg.Exporters.Zipkin != nil {
+			return nil, errors.New("boom")
+		}
+		return new(dummyExporter), nil
+	})
+
+	srvCfg := func(rate int, layers map[string]interface{}, tagPath bool) config.ServiceConfig {
+		return config.ServiceConfig{ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				"sample_rate":    rate,
+				"enabled_layers": layers,
+				"exporters": map[string]interface{}{
+					"prometheus": map[string]interface{}{"tag_path": tagPath},
+				},
+			},
+		}}
+	}
+
+	ctx := context.Background()
+	if err := Register(ctx, srvCfg(10, map[string]interface{}{"router": true}, true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register(ctx, srvCfg(10, nil, false)); err != errSingletonExporterFactoriesRegister {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !IsRouterEnabled() || IsPipeEnabled() || IsBackendEnabled() {
+		t.Errorf("unexpected layers: %+v", currentLayers())
+	}
+	if len(viewExporters) != 1 || len(traceExporters) != 1 {
+		t.Errorf("unexpected exporters: %d view, %d trace", len(viewExporters), len(traceExporters))
+	}
+	if v := registeredViews[ochttpServerLatency]; v == nil || len(v.TagKeys) != 1 {
+		t.Errorf("unexpected server latency view: %+v", v)
+	}
+
+	if err := Reload(ctx, srvCfg(50, map[string]interface{}{"pipe": true, "backend": true}, false)); err != nil {
+		t.Fatal(err)
+	}
+	if IsRouterEnabled() || !IsPipeEnabled() || !IsBackendEnabled() {
+		t.Errorf("unexpected layers: %+v", currentLayers())
+	}
+	if len(viewExporters) != 1 || len(traceExporters) != 1 {
+		t.Errorf("unexpected exporters: %d view, %d trace", len(viewExporters), len(traceExporters))
+	}
+	if unregisteredViews != len(DefaultViews) {
+		t.Errorf("unexpected number of unregistered views: %d", unregisteredViews)
+	}
+	if v := registeredViews[ochttpServerLatency]; v == nil || len(v.TagKeys) != 0 {
+		t.Errorf("unexpected server latency view: %+v", v)
+	}
+	if len(sampleRates) != 2 || sampleRates[1] != 50 {
+		t.Errorf("unexpected sample rates: %v", sampleRates)
+	}
+	if len(factoryContexts) != 2 {
+		t.Fatalf("unexpected number of factory calls: %d", len(factoryContexts))
+	}
+	if factoryContexts[0].Err() == nil {
+		t.Error("the context of the replaced exporters should be canceled")
+	}
+	if factoryContexts[1].Err() != nil {
+		t.Error("the context of the current exporters should be alive")
+	}
+
+	// the failed reloads must keep the previous setup running
+	for i, extra := range []map[string]interface{}{
+		{"propagation": []interface{}{"w3cc"}},
+		{"debug_header": map[string]interface{}{"name": "X-Debug", "allowed_cidrs": []interface{}{"nope"}}},
+		{"strict_exporters": true, "exporters": map[string]interface{}{"zipkin": map[string]interface{}{}}},
+	} {
+		cfg := srvCfg(70, nil, true)
+		for k, v := range extra {
+			cfg.ExtraConfig[Namespace].(map[string]interface{})[k] = v
+		}
+		if err := Reload(ctx, cfg); err == nil {
+			t.Errorf("tc-%d: error expected", i)
+		}
+		if IsRouterEnabled() || !IsPipeEnabled() || !IsBackendEnabled() {
+			t.Errorf("tc-%d: unexpected layers: %+v", i, currentLayers())
+		}
+		if len(viewExporters) != 1 || len(traceExporters) != 1 {
+			t.Errorf("tc-%d: unexpected exporters: %d view, %d trace", i, len(viewExporters), len(traceExporters))
+		}
+		if len(registeredViews) != len(DefaultViews) {
+			t.Errorf("tc-%d: unexpected number of registered views: %d", i, len(registeredViews))
+		}
+		if len(sampleRates) != 2 {
+			t.Errorf("tc-%d: unexpected sample rates: %v", i, sampleRates)
+		}
+		if factoryContexts[1].Err() != nil {
+			t.Errorf("tc-%d: the context of the current exporters should be alive", i)
+		}
+	}
+	if last := factoryContexts[len(factoryContexts)-1]; last.Err() == nil {
+		t.Error("the context of the discarded exporters should be canceled")
+	}
+
+	Unregister()
+	if len(viewExporters) != 0 || len(traceExporters) != 0 || len(registeredViews) != 0 {
+		t.Errorf("unexpected leftovers: %d view exporters, %d trace exporters, %d views", len(viewExporters), len(traceExporters), len(registeredViews))
+	}
+	if factoryContexts[1].Err() == nil {
+		t.Error("the context of the unregistered exporters should be canceled")
+	}
+}
+
+const ochttpServerLatency = "opencensus.io/http/server/latency"
+
+type dummyExporter struct{}
+
+func (*dummyExporter) ExportView(_ *view.Data) {}
+
+func (*dummyExporter) ExportSpan(_ *trace.SpanData) {}
+
+func TestComposableRegister_ExporterFactories(t *testing.T) {
+	c := composableRegister{
+		viewExporter:  func(_ ...view.Exporter) { t.Error("the exporters should not be registered") },
+		traceExporter: func(_ ...trace.Exporter) { t.Error("the exporters should not be registered") },
+	}
+	errBoom := errors.New("boom")
+	fs := []namedExporterFactory{
+		{
+			name: "disabled",
+			factory: func(_ context.Context, _ Config) (interface{}, error) {
+				return nil, fmt.Errorf("foo %w", ErrExporterDisabled)
+			},
+		},
+		{
+			name:    "broken",
+			factory: func(_ context.Context, _ Config) (interface{}, error) { return nil, errBoom },
+		},
+		{
+			name:    "working",
+			factory: func(_ context.Context, _ Config) (interface{}, error) { return new(dummyExporter), nil },
+		},
+	}
+
+	ve, te, errs := c.ExporterFactories(context.Background(), Config{}, fs)
+	if len(ve) != 1 || len(te) != 1 {
+		t.Errorf("unexpected exporters: %d view, %d trace", len(ve), len(te))
+	}
+	if len(errs) != 1 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if errs[0].Name != "broken" || !errors.Is(errs, errBoom) {
+		t.Errorf("unexpected error: %v", errs[0])
+	}
+	if msg := errs.Error(); msg != "opencensus exporters failed: exporter broken: boom" {
+		t.Errorf("unexpected error message: %s", msg)
+	}
+}
+
+func TestGetSamplerForEndpoint(t *testing.T) {
+	for i, tc := range []struct {
+		cfg     *config.EndpointConfig
+		isNil   bool
+		sampled bool
+	}{
+		{
+			isNil: true,
+		},
+		{
+			cfg:   &config.EndpointConfig{Endpoint: "/api/:foo"},
+			isNil: true,
+		},
+		{
+			cfg: &config.EndpointConfig{
+				Endpoint: "/api/:foo",
+				ExtraConfig: config.ExtraConfig{
+					Namespace: map[string]interface{}{"path_aggregation": "lastparam"},
+				},
+			},
+			isNil: true,
+		},
+		{
+			cfg: &config.EndpointConfig{
+				Endpoint: "/api/:foo",
+				ExtraConfig: config.ExtraConfig{
+					Namespace: map[string]interface{}{"sample_rate": 0},
+				},
+			},
+			sampled: false,
+		},
+		{
+			cfg: &config.EndpointConfig{
+				Endpoint: "/api/:foo",
+				ExtraConfig: config.ExtraConfig{
+					Namespace: map[string]interface{}{"sample_rate": 100},
+				},
+			},
+			sampled: true,
+		},
+	} {
+		sampler := GetSamplerForEndpoint(tc.cfg)
+		if tc.isNil {
+			if sampler != nil {
+				t.Errorf("tc-%d: unexpected sampler", i)
+			}
+			continue
+		}
+		if sampler == nil {
+			t.Errorf("tc-%d: sampler expected", i)
+			continue
+		}
+		if d := sampler(trace.SamplingParameters{}); d.Sample != tc.sampled {
+			t.Errorf("tc-%d: unexpected decision: %v", i, d.Sample)
+		}
+	}
+}
+
+func TestComposableRegister_Views_metricTags(t *testing.T) {
+	c := composableRegister{
+		registerViews: func(_ ...*view.View) error {
+			t.Error("the views should not be registered")
+			return nil
+		},
+	}
+
+	for i, cfg := range []Config{
+		{Metrics: &MetricsConfig{PathTag: true, StatusCodeTag: true}},
+		{Exporters: Exporters{Prometheus: &PrometheusConfig{PathTag: true, StatusCodeTag: true}}},
+		{
+			Metrics:   &MetricsConfig{PathTag: true},
+			Exporters: Exporters{Prometheus: &PrometheusConfig{StatusCodeTag: true}},
+		},
+	} {
+		vs, err := c.Views(cfg, nil)
+		if err != nil {
+			t.Errorf("tc-%d: unexpected error: %v", i, err)
+			continue
+		}
+		for _, v := range vs {
+			if v.Name != ochttpServerLatency {
+				continue
+			}
+			if len(v.TagKeys) != 2 || v.TagKeys[0] != ochttp.Path || v.TagKeys[1] != ochttp.StatusCode {
+				t.Errorf("tc-%d: unexpected tag keys: %v", i, v.TagKeys)
+			}
+		}
+	}
+
+	if len(ochttp.ServerLatencyView.TagKeys) != 0 {
+		t.Errorf("the default views should not be modified: %v", ochttp.ServerLatencyView.TagKeys)
+	}
+}
@@ -0,0 +1,140 @@
+package opencensus
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// PathRewriteRule replaces the matches of the regular expression in the request path with the
+// replacement, which can reference the capture groups as in regexp.Regexp.ReplaceAllString
+type PathRewriteRule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// QueryParamConfig selects a query parameter to include in the aggregated path
+type QueryParamConfig struct {
+	Name string `json:"name"`
+	// AllowedValues limits the accepted values of the parameter. Any other value is replaced
+	// by "other". An empty list accepts any value (use with caution!)
+	AllowedValues []string `json:"allowed_values"`
+}
+
+const otherQueryParamValue = "other"
+
+var (
+	numericSegment = regexp.MustCompile(`^[0-9]+$`)
+	uuidSegment    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hexSegment     = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+	base64Segment  = regexp.MustCompile(`^[A-Za-z0-9+/_-]{20,}={0,2}$`)
+	hasDigit       = regexp.MustCompile(`[0-9]`)
+	hasLetter      = regexp.MustCompile(`[A-Za-z]`)
+)
+
+// rewritePathExtractor returns the path extractor of the rules and auto aggregation modes, or
+// nil for the rest of the modes and for the rules mode without valid rules
+func rewritePathExtractor(mode string, cfg *EndpointExtraConfig) func(r *http.Request) string {
+	switch mode {
+	case aggregationModeAuto:
+		return autoPathExtractor
+	case aggregationModeRules:
+		if cfg == nil {
+			return nil
+		}
+		return rulesPathExtractor(cfg.PathRules)
+	}
+	return nil
+}
+
+func rulesPathExtractor(rules []PathRewriteRule) func(r *http.Request) string {
+	if len(rules) == 0 {
+		getLogger().Warning(logPrefix, "the rules path aggregation requires at least one rule. Using the pattern mode")
+		return nil
+	}
+	res := make([]*regexp.Regexp, len(rules))
+	for i, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			getLogger().Error(logPrefix, "invalid path aggregation rule:", err.Error(), "Using the pattern mode")
+			return nil
+		}
+		res[i] = re
+	}
+	return func(r *http.Request) string {
+		path := r.URL.Path
+		for i, re := range res {
+			path = re.ReplaceAllString(path, rules[i].Replacement)
+		}
+		return strings.ToLower(path)
+	}
+}
+
+// autoPathExtractor replaces the path segments looking like identifiers with placeholders
+func autoPathExtractor(r *http.Request) string {
+	segments := strings.Split(r.URL.Path, "/")
+	for i, segment := range segments {
+		switch {
+		case segment == "":
+		case numericSegment.MatchString(segment):
+			segments[i] = "{num}"
+		case uuidSegment.MatchString(segment):
+			segments[i] = "{uuid}"
+		case hexSegment.MatchString(segment) && hasDigit.MatchString(segment):
+			segments[i] = "{hex}"
+		case base64Segment.MatchString(segment) && hasDigit.MatchString(segment) && hasLetter.MatchString(segment):
+			segments[i] = "{base64}"
+		}
+	}
+	return strings.ToLower(strings.Join(segments, "/"))
+}
+
+// withQueryParams appends the selected query parameters to the aggregated path
+func withQueryParams(extractor func(r *http.Request) string, params []QueryParamConfig) func(r *http.Request) string {
+	suffix := queryParamsSuffix(params)
+	if suffix == nil {
+		return extractor
+	}
+	return func(r *http.Request) string {
+		return extractor(r) + suffix(r)
+	}
+}
+
+// queryParamsSuffix returns a function rendering the selected query parameters present in
+// the request as a query string (starting with ?), or nil if there are no parameters to select
+func queryParamsSuffix(params []QueryParamConfig) func(r *http.Request) string {
+	if len(params) == 0 {
+		return nil
+	}
+	allowed := make([]map[string]struct{}, len(params))
+	for i, p := range params {
+		if len(p.AllowedValues) == 0 {
+			continue
+		}
+		allowed[i] = make(map[string]struct{}, len(p.AllowedValues))
+		for _, v := range p.AllowedValues {
+			allowed[i][v] = struct{}{}
+		}
+	}
+	return func(r *http.Request) string {
+		query := r.URL.Query()
+		parts := make([]string, 0, len(params))
+		for i, p := range params {
+			if !query.Has(p.Name) {
+				continue
+			}
+			v := query.Get(p.Name)
+			if allowed[i] != nil {
+				if _, ok := allowed[i][v]; !ok {
+					v = otherQueryParamValue
+				}
+			}
+			parts = append(parts, url.QueryEscape(p.Name)+"="+url.QueryEscape(v))
+		}
+		if len(parts) == 0 {
+			return ""
+		}
+		return "?" + strings.Join(parts, "&")
+	}
+}
@@ -0,0 +1,136 @@
+package opencensus
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/luraproject/lura/v2/config"
+)
+
+func TestGetAggregatedPathForMetrics_rules(t *testing.T) {
+	for i, tc := range []struct {
+		extraCfg map[string]interface{}
+		url      string
+		expected string
+	}{
+		{
+			extraCfg: map[string]interface{}{
+				"path_aggregation": "rules",
+				"path_rules": []interface{}{
+					map[string]interface{}{"pattern": `^/legacy/users/[^/]+`, "replacement": "/legacy/users/{user}"},
+					map[string]interface{}{"pattern": `/orders/[0-9]+$`, "replacement": "/orders/{order}"},
+				},
+			},
+			url:      "https://example.tld/legacy/users/john/orders/123",
+			expected: "/legacy/users/{user}/orders/{order}",
+		},
+		{
+			extraCfg: map[string]interface{}{
+				"path_aggregation": "rules",
+				"path_rules": []interface{}{
+					map[string]interface{}{"pattern": `^/legacy/(users|groups)/[^/]+`, "replacement": "/legacy/$1/{id}"},
+				},
+			},
+			url:      "https://example.tld/legacy/groups/admins",
+			expected: "/legacy/groups/{id}",
+		},
+		{
+			extraCfg: map[string]interface{}{
+				"path_aggregation": "rules",
+				"path_rules": []interface{}{
+					map[string]interface{}{"pattern": `^/legacy/(users`, "replacement": "/legacy"},
+				},
+			},
+			url:      "https://example.tld/legacy/users/john",
+			expected: "/legacy/*",
+		},
+		{
+			extraCfg: map[string]interface{}{"path_aggregation": "rules"},
+			url:      "https://example.tld/legacy/users/john",
+			expected: "/legacy/*",
+		},
+		{
+			extraCfg: map[string]interface{}{"path_aggregation": "auto"},
+			url:      "https://example.tld/legacy/users/1234/sessions/123e4567-e89b-12d3-a456-426614174000",
+			expected: "/legacy/users/{num}/sessions/{uuid}",
+		},
+		{
+			extraCfg: map[string]interface{}{"path_aggregation": "auto"},
+			url:      "https://example.tld/legacy/objects/5f2b6c9e1a4d3e0012345678/internationalization",
+			expected: "/legacy/objects/{hex}/internationalization",
+		},
+		{
+			extraCfg: map[string]interface{}{"path_aggregation": "auto"},
+			url:      "https://example.tld/legacy/tokens/dGhpcyBpcyBhIHRva2VuMTIz==/",
+			expected: "/legacy/tokens/{base64}/",
+		},
+		{
+			extraCfg: map[string]interface{}{"path_aggregation": "auto"},
+			url:      "https://example.tld/Legacy/Users/1234/PROFILE",
+			expected: "/legacy/users/{num}/profile",
+		},
+		{
+			extraCfg: map[string]interface{}{
+				"path_aggregation": "rules",
+				"path_rules": []interface{}{
+					map[string]interface{}{"pattern": `/orders/[0-9]+$`, "replacement": "/orders/{order}"},
+				},
+			},
+			url:      "https://example.tld/Legacy/Users/John/orders/123",
+			expected: "/legacy/users/john/orders/{order}",
+		},
+	} {
+		cfg := &config.EndpointConfig{
+			Endpoint:    "/legacy/*",
+			ExtraConfig: config.ExtraConfig{Namespace: tc.extraCfg},
+		}
+		r, _ := http.NewRequest("GET", tc.url, http.NoBody)
+		if tag := GetAggregatedPathForMetrics(cfg)(r); tag != tc.expected {
+			t.Errorf("tc-%d: unexpected endpoint result: %s", i, tag)
+		}
+
+		backendCfg := &config.Backend{
+			URLPattern:  "/legacy/*",
+			ExtraConfig: config.ExtraConfig{Namespace: tc.extraCfg},
+		}
+		if tag := GetAggregatedPathForBackendMetrics(backendCfg)(r); tag != tc.expected {
+			t.Errorf("tc-%d: unexpected backend result: %s", i, tag)
+		}
+	}
+}
+
+func TestGetAggregatedPathForMetrics_queryParams(t *testing.T) {
+	extraCfg := config.ExtraConfig{
+		Namespace: map[string]interface{}{
+			"query_params": []interface{}{
+				map[string]interface{}{"name": "action", "allowed_values": []interface{}{"create", "delete"}},
+				map[string]interface{}{"name": "version"},
+			},
+		},
+	}
+	endpoint := &config.EndpointConfig{Endpoint: "/api/ops", ExtraConfig: extraCfg}
+	backend := &config.Backend{URLPattern: "/ops", ExtraConfig: extraCfg}
+
+	for i, tc := range []struct {
+		url      string
+		expected string
+	}{
+		{url: "https://example.tld/api/ops?action=create&foo=bar", expected: "?action=create"},
+		{url: "https://example.tld/api/ops?version=2&action=drop", expected: "?action=other&version=2"},
+		{url: "https://example.tld/api/ops?foo=bar", expected: ""},
+	} {
+		r, _ := http.NewRequest("GET", tc.url, http.NoBody)
+		if tag := GetAggregatedPathForMetrics(endpoint)(r); tag != "/api/ops"+tc.expected {
+			t.Errorf("tc-%d: unexpected endpoint result: %s", i, tag)
+		}
+		if tag := GetAggregatedPathForBackendMetrics(backend)(r); tag != "/ops"+tc.expected {
+			t.Errorf("tc-%d: unexpected backend result: %s", i, tag)
+		}
+		if name := GetSpanNameForEndpoint(endpoint)(r); name != "/api/ops"+tc.expected {
+			t.Errorf("tc-%d: unexpected router span name: %s", i, name)
+		}
+		if name := GetSpanNameForBackendRequest(backend)(r); name != "/api/ops"+tc.expected {
+			t.Errorf("tc-%d: unexpected client span name: %s", i, name)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package opencensus
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"go.opencensus.io/plugin/ochttp/propagation/b3"
+	"go.opencensus.io/plugin/ochttp/propagation/tracecontext"
+	"go.opencensus.io/trace"
+	"go.opencensus.io/trace/propagation"
+)
+
+// Names of the supported propagation formats
+const (
+	PropagationW3C      = "w3c"
+	PropagationB3       = "b3"
+	PropagationB3Single = "b3_single"
+	PropagationJaeger   = "jaeger"
+	PropagationAWS      = "aws"
+	PropagationGCP      = "gcp"
+)
+
+var currentPropagation atomic.Pointer[propagation.HTTPFormat]
+
+// PropagationFormat returns the trace propagation format defined by the propagation option,
+// B3 if it is not configured
+func PropagationFormat() propagation.HTTPFormat {
+	if f := currentPropagation.Load(); f != nil {
+		return *f
+	}
+	return &b3.HTTPFormat{}
+}
+
+func newPropagation(names []string) (propagation.HTTPFormat, error) {
+	if len(names) == 0 {
+		return &b3.HTTPFormat{}, nil
+	}
+	formats := make([]propagation.HTTPFormat, len(names))
+	for i, name := range names {
+		switch strings.ToLower(name) {
+		case PropagationW3C, "tracecontext":
+			formats[i] = &tracecontext.HTTPFormat{}
+		case PropagationB3:
+			formats[i] = &b3.HTTPFormat{}
+		case PropagationB3Single:
+			formats[i] = b3SingleFormat{}
+		case PropagationJaeger:
+			formats[i] = jaegerFormat{}
+		case PropagationAWS:
+			formats[i] = awsFormat{}
+		case PropagationGCP:
+			formats[i] = gcpFormat{}
+		default:
+			return nil, fmt.Errorf("unknown propagation format %q", name)
+		}
+	}
+	if len(formats) == 1 {
+		return formats[0], nil
+	}
+	return compositeFormat(formats), nil
+}
+
+// compositeFormat extracts the span context from the first format found in the request
+// and injects all of them
+type compositeFormat []propagation.HTTPFormat
+
+func (c compositeFormat) SpanContextFromRequest(req *http.Request) (trace.SpanContext, bool) {
+	for _, f := range c {
+		if sc, ok := f.SpanContextFromRequest(req); ok {
+			return sc, true
+		}
+	}
+	return trace.SpanContext{}, false
+}
+
+func (c compositeFormat) SpanContextToRequest(sc trace.SpanContext, req *http.Request) {
+	for _, f := range c {
+		f.SpanContextToRequest(sc, req)
+	}
+}
+
+const b3SingleHeader = "b3"
+
+// b3SingleFormat implements the single header version of B3: {trace_id}-{span_id}-{sampled}-{parent_span_id}
+type b3SingleFormat struct{}
+
+func (b3SingleFormat) SpanContextFromRequest(req *http.Request) (trace.SpanContext, bool) {
+	parts := strings.Split(req.Header.Get(b3SingleHeader), "-")
+	if len(parts) < 2 {
+		// a single value only carries the sampling decision
+		return trace.SpanContext{}, false
+	}
+	tid, ok := b3.ParseTraceID(parts[0])
+	if !ok {
+		return trace.SpanContext{}, false
+	}
+	sid, ok := b3.ParseSpanID(parts[1])
+	if !ok {
+		return trace.SpanContext{}, false
+	}
+	sc := trace.SpanContext{TraceID: tid, SpanID: sid}
+	if len(parts) > 2 && (parts[2] == "1" || parts[2] == "d") {
+		sc.TraceOptions = 1
+	}
+	return sc, true
+}
+
+func (b3SingleFormat) SpanContextToRequest(sc trace.SpanContext, req *http.Request) {
+	req.Header.Set(b3SingleHeader, hex.EncodeToString(sc.TraceID[:])+"-"+hex.EncodeToString(sc.SpanID[:])+"-"+sampledFlag(sc))
+}
+
+const jaegerHeader = "uber-trace-id"
+
+// jaegerFormat implements the jaeger header: {trace_id}:{span_id}:{parent_span_id}:{flags}
+type jaegerFormat struct{}
+
+func (jaegerFormat) SpanContextFromRequest(req *http.Request) (trace.SpanContext, bool) {
+	parts := strings.Split(req.Header.Get(jaegerHeader), ":")
+	if len(parts) != 4 {
+		return trace.SpanContext{}, false
+	}
+	tid, ok := parseHexTraceID(parts[0])
+	if !ok {
+		return trace.SpanContext{}, false
+	}
+	sid, ok := parseHexSpanID(parts[1])
+	if !ok {
+		return trace.SpanContext{}, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	return trace.SpanContext{TraceID: tid, SpanID: sid, TraceOptions: trace.TraceOptions(flags & 1)}, true
+}
+
+func (jaegerFormat) SpanContextToRequest(sc trace.SpanContext, req *http.Request) {
+	req.Header.Set(jaegerHeader, hex.EncodeToString(sc.TraceID[:])+":"+hex.EncodeToString(sc.SpanID[:])+":0:"+sampledFlag(sc))
+}
+
+const awsHeader = "X-Amzn-Trace-Id"
+
+// awsFormat implements the AWS X-Ray header: Root=1-{epoch}-{random};Parent={span_id};Sampled={flag}
+type awsFormat struct{}
+
+func (awsFormat) SpanContextFromRequest(req *http.Request) (trace.SpanContext, bool) {
+	var sc trace.SpanContext
+	var hasRoot, hasParent bool
+	for _, part := range strings.Split(req.Header.Get(awsHeader), ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "Root":
+			root := strings.Split(v, "-")
+			if len(root) != 3 || root[0] != "1" || len(root[1]) != 8 || len(root[2]) != 24 {
+				return trace.SpanContext{}, false
+			}
+			sc.TraceID, hasRoot = parseHexTraceID(root[1] + root[2])
+		case "Parent":
+			sc.SpanID, hasParent = parseHexSpanID(v)
+		case "Sampled":
+			if v == "1" {
+				sc.TraceOptions = 1
+			}
+		}
+	}
+	return sc, hasRoot && hasParent
+}
+
+func (awsFormat) SpanContextToRequest(sc trace.SpanContext, req *http.Request) {
+	tid := hex.EncodeToString(sc.TraceID[:])
+	req.Header.Set(awsHeader, "Root=1-"+tid[:8]+"-"+tid[8:]+";Parent="+hex.EncodeToString(sc.SpanID[:])+";Sampled="+sampledFlag(sc))
+}
+
+const gcpHeader = "X-Cloud-Trace-Context"
+
+// gcpFormat implements the Google Cloud header: {trace_id}/{decimal_span_id};o={flag}
+type gcpFormat struct{}
+
+func (gcpFormat) SpanContextFromRequest(req *http.Request) (trace.SpanContext, bool) {
+	h, opts, _ := strings.Cut(req.Header.Get(gcpHeader), ";")
+	tidPart, sidPart, ok := strings.Cut(h, "/")
+	if !ok || len(tidPart) != 32 {
+		return trace.SpanContext{}, false
+	}
+	tid, ok := parseHexTraceID(tidPart)
+	if !ok {
+		return trace.SpanContext{}, false
+	}
+	sid, err := strconv.ParseUint(sidPart, 10, 64)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	sc := trace.SpanContext{TraceID: tid}
+	binary.BigEndian.PutUint64(sc.SpanID[:], sid)
+	if opts == "o=1" {
+		sc.TraceOptions = 1
+	}
+	return sc, true
+}
+
+func (gcpFormat) SpanContextToRequest(sc trace.SpanContext, req *http.Request) {
+	sid := binary.BigEndian.Uint64(sc.SpanID[:])
+	req.Header.Set(gcpHeader, hex.EncodeToString(sc.TraceID[:])+"/"+strconv.FormatUint(sid, 10)+";o="+sampledFlag(sc))
+}
+
+func sampledFlag(sc trace.SpanContext) string {
+	if sc.IsSampled() {
+		return "1"
+	}
+	return "0"
+}
+
+// parseHexTraceID parses a hex encoded trace id of up to 128 bits, left padding it with zeros
+func parseHexTraceID(s string) (trace.TraceID, bool) {
+	var tid trace.TraceID
+	if s == "" || len(s) > 32 {
+		return tid, false
+	}
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return tid, false
+	}
+	copy(tid[16-len(b):], b)
+	return tid, tid != trace.TraceID{}
+}
+
+// parseHexSpanID parses a hex encoded span id of up to 64 bits, left padding it with zeros
+func parseHexSpanID(s string) (trace.SpanID, bool) {
+	var sid trace.SpanID
+	if s == "" || len(s) > 16 {
+		return sid, false
+	}
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return sid, false
+	}
+	copy(sid[8-len(b):], b)
+	return sid, sid != trace.SpanID{}
+}
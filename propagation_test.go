@@ -0,0 +1,87 @@
+package opencensus
+
+import (
+	"net/http"
+	"testing"
+
+	"go.opencensus.io/trace"
+)
+
+func TestNewPropagation(t *testing.T) {
+	sc := trace.SpanContext{
+		TraceID:      trace.TraceID{0x5f, 0x0b, 0x1c, 0x2d, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+		SpanID:       trace.SpanID{0, 0, 0, 0, 0, 0, 0x30, 0x39},
+		TraceOptions: 1,
+	}
+
+	for i, tc := range []struct {
+		format string
+		header string
+		value  string
+	}{
+		{format: PropagationW3C, header: "traceparent", value: "00-5f0b1c2d0102030405060708090a0b0c-0000000000003039-01"},
+		{format: PropagationB3, header: "X-B3-TraceId", value: "5f0b1c2d0102030405060708090a0b0c"},
+		{format: PropagationB3Single, header: "b3", value: "5f0b1c2d0102030405060708090a0b0c-0000000000003039-1"},
+		{format: PropagationJaeger, header: "uber-trace-id", value: "5f0b1c2d0102030405060708090a0b0c:0000000000003039:0:1"},
+		{format: PropagationAWS, header: "X-Amzn-Trace-Id", value: "Root=1-5f0b1c2d-0102030405060708090a0b0c;Parent=0000000000003039;Sampled=1"},
+		{format: PropagationGCP, header: "X-Cloud-Trace-Context", value: "5f0b1c2d0102030405060708090a0b0c/12345;o=1"},
+	} {
+		f, err := newPropagation([]string{tc.format})
+		if err != nil {
+			t.Errorf("tc-%d: %v", i, err)
+			continue
+		}
+		req, _ := http.NewRequest("GET", "http://example.com", http.NoBody)
+		f.SpanContextToRequest(sc, req)
+		if v := req.Header.Get(tc.header); v != tc.value {
+			t.Errorf("tc-%d: unexpected header. have %q, want %q", i, v, tc.value)
+		}
+		res, ok := f.SpanContextFromRequest(req)
+		if !ok {
+			t.Errorf("tc-%d: unable to extract the span context", i)
+			continue
+		}
+		if res.TraceID != sc.TraceID || res.SpanID != sc.SpanID || res.IsSampled() != sc.IsSampled() {
+			t.Errorf("tc-%d: unexpected span context: %+v", i, res)
+		}
+	}
+}
+
+func TestNewPropagation_composite(t *testing.T) {
+	f, err := newPropagation([]string{"w3c", "b3_single", "jaeger"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "http://example.com", http.NoBody)
+	req.Header.Set("uber-trace-id", "abc:1:0:1")
+	req.Header.Set("b3", "1")
+	sc, ok := f.SpanContextFromRequest(req)
+	if !ok {
+		t.Fatal("unable to extract the span context")
+	}
+	if sc.TraceID != (trace.TraceID{14: 0x0a, 15: 0xbc}) || sc.SpanID != (trace.SpanID{7: 1}) || !sc.IsSampled() {
+		t.Errorf("unexpected span context: %+v", sc)
+	}
+
+	req.Header.Set("traceparent", "00-5f0b1c2d0102030405060708090a0b0c-0000000000003039-00")
+	sc, ok = f.SpanContextFromRequest(req)
+	if !ok {
+		t.Fatal("unable to extract the span context")
+	}
+	if sc.SpanID != (trace.SpanID{6: 0x30, 7: 0x39}) || sc.IsSampled() {
+		t.Errorf("the first configured format should win: %+v", sc)
+	}
+
+	out, _ := http.NewRequest("GET", "http://example.com", http.NoBody)
+	f.SpanContextToRequest(sc, out)
+	for _, h := range []string{"traceparent", "b3", "uber-trace-id"} {
+		if out.Header.Get(h) == "" {
+			t.Errorf("header %s not injected", h)
+		}
+	}
+
+	if _, err := newPropagation([]string{"w3c", "unknown"}); err == nil {
+		t.Error("error expected for unknown formats")
+	}
+}
@@ -2,18 +2,32 @@ package opencensus
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/luraproject/lura/v2/config"
 	"github.com/luraproject/lura/v2/proxy"
+	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/trace"
 )
 
-const errCtxCanceledMsg = "context canceled"
+// ErrorTypeAttribute is the span attribute holding the class of the error
+// returned by the pipe and backend layers
+const ErrorTypeAttribute = "error.type"
 
+// Middleware traces the requests going through the wrapped proxy with spans named after name,
+// and records the pipe stats tagging name as the endpoint. It does not know the endpoint or the
+// backend it wraps, so it ignores the exclusion rules. Use ProxyFactory or BackendFactory to
+// get them.
 func Middleware(name string) proxy.Middleware {
 	if !IsPipeEnabled() {
 		return proxy.EmptyMiddleware
 	}
+	return middleware(name, newPipeStats(name), nil)
+}
+
+func middleware(name string, ls *layerStats, exclusions func(method, path string) Exclusion) proxy.Middleware {
 	return func(next ...proxy.Proxy) proxy.Proxy {
 		if len(next) > 1 {
 			panic(proxy.ErrTooManyProxies)
@@ -22,24 +36,100 @@ func Middleware(name string) proxy.Middleware {
 			panic(proxy.ErrNotEnoughProxies)
 		}
 		return func(ctx context.Context, req *proxy.Request) (*proxy.Response, error) {
+			var ex Exclusion
+			if exclusions != nil {
+				ex = exclusions(req.Method, req.Path)
+			}
+			if ex.Traces && ex.Stats {
+				return next[0](ctx, req)
+			}
+
+			start := time.Now()
 			var span *trace.Span
-			ctx, span = trace.StartSpan(trace.NewContext(ctx, fromContext(ctx)), name)
+			if !ex.Traces {
+				ctx, span = trace.StartSpan(trace.NewContext(ctx, fromContext(ctx)), name)
+			}
 			resp, err := next[0](ctx, req)
-			if err != nil {
-				if err.Error() != errCtxCanceledMsg {
-					span.AddAttributes(trace.StringAttribute("error", err.Error()))
-				} else {
-					span.AddAttributes(trace.BoolAttribute("canceled", true))
-				}
+			if ls != nil && !ex.Stats {
+				ls.record(ctx, start, resp, err)
+			}
+			if span != nil {
+				setSpanOutcome(span, resp, err)
+				span.End()
 			}
-			span.AddAttributes(trace.BoolAttribute("complete", resp != nil && resp.IsComplete))
-			span.End()
 
 			return resp, err
 		}
 	}
 }
 
+func setSpanOutcome(span *trace.Span, resp *proxy.Response, err error) {
+	span.AddAttributes(trace.BoolAttribute("complete", resp != nil && resp.IsComplete))
+
+	statusCode := 0
+	if resp != nil {
+		statusCode = resp.Metadata.StatusCode
+	}
+
+	if err == nil {
+		if statusCode != 0 {
+			span.AddAttributes(trace.Int64Attribute(ochttp.StatusCodeAttribute, int64(statusCode)))
+			span.SetStatus(TraceStatus(statusCode, ""))
+		}
+		return
+	}
+
+	class := errorClass(err)
+	span.AddAttributes(trace.StringAttribute(ErrorTypeAttribute, class))
+
+	var re responseError
+	switch {
+	case class == errorClassCanceled:
+		span.AddAttributes(trace.BoolAttribute("canceled", true))
+		span.SetStatus(trace.Status{Code: trace.StatusCodeCancelled, Message: err.Error()})
+		return
+	case class == errorClassDeadlineExceeded:
+		span.SetStatus(trace.Status{Code: trace.StatusCodeDeadlineExceeded, Message: err.Error()})
+	case errors.As(err, &re):
+		statusCode = re.StatusCode()
+		span.AddAttributes(trace.Int64Attribute(ochttp.StatusCodeAttribute, int64(statusCode)))
+		span.SetStatus(TraceStatus(statusCode, ""))
+	default:
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+	}
+	span.AddAttributes(trace.StringAttribute("error", err.Error()))
+}
+
+// responseError is implemented by the errors lura returns when a backend
+// replies with an unexpected status code
+type responseError interface {
+	error
+	StatusCode() int
+}
+
+const (
+	errorClassCanceled         = "canceled"
+	errorClassDeadlineExceeded = "deadline_exceeded"
+	errorClassOther            = "other"
+)
+
+// errorClass returns a low cardinality classification of the error, suitable
+// for span attributes and metric tags
+func errorClass(err error) string {
+	var re responseError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return errorClassCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return errorClassDeadlineExceeded
+	case errors.As(err, &re):
+		if code := re.StatusCode(); code >= 100 && code < 600 {
+			return fmt.Sprintf("http_%dxx", code/100)
+		}
+	}
+	return errorClassOther
+}
+
 func ProxyFactory(pf proxy.Factory) proxy.FactoryFunc {
 	if !IsPipeEnabled() {
 		return pf.New
@@ -49,7 +139,7 @@ func ProxyFactory(pf proxy.Factory) proxy.FactoryFunc {
 		if err != nil {
 			return next, err
 		}
-		return Middleware("pipe-" + cfg.Endpoint)(next), nil
+		return middleware(GetPipeSpanName(cfg), newPipeStats(cfg.Endpoint), GetExclusionsForEndpoint(cfg, LayerPipe))(next), nil
 	}
 }
 
@@ -58,6 +148,7 @@ func BackendFactory(bf proxy.BackendFactory) proxy.BackendFactory {
 		return bf
 	}
 	return func(cfg *config.Backend) proxy.Proxy {
-		return Middleware("backend-" + cfg.URLPattern)(bf(cfg))
+		ls := newBackendStats(cfg.ParentEndpoint, cfg.URLPattern)
+		return middleware(GetBackendSpanName(cfg), ls, GetExclusionsForBackend(cfg))(bf(cfg))
 	}
 }
@@ -0,0 +1,170 @@
+package opencensus
+
+import (
+	"context"
+	"time"
+
+	"github.com/luraproject/lura/v2/proxy"
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+var (
+	PipeLatency = stats.Float64(
+		"krakend.io/proxy/latency",
+		"End-to-end latency of the pipe layer",
+		stats.UnitMilliseconds,
+	)
+	PipeErrors = stats.Int64(
+		"krakend.io/proxy/errors",
+		"Number of errors returned by the pipe layer",
+		stats.UnitDimensionless,
+	)
+	PipeCanceled = stats.Int64(
+		"krakend.io/proxy/canceled",
+		"Number of requests canceled in the pipe layer",
+		stats.UnitDimensionless,
+	)
+	PipeIncomplete = stats.Int64(
+		"krakend.io/proxy/incomplete",
+		"Number of incomplete responses returned by the pipe layer",
+		stats.UnitDimensionless,
+	)
+
+	BackendLatency = stats.Float64(
+		"krakend.io/backend/latency",
+		"End-to-end latency of the backend layer",
+		stats.UnitMilliseconds,
+	)
+	BackendErrors = stats.Int64(
+		"krakend.io/backend/errors",
+		"Number of errors returned by the backend layer",
+		stats.UnitDimensionless,
+	)
+	BackendCanceled = stats.Int64(
+		"krakend.io/backend/canceled",
+		"Number of requests canceled in the backend layer",
+		stats.UnitDimensionless,
+	)
+	BackendIncomplete = stats.Int64(
+		"krakend.io/backend/incomplete",
+		"Number of incomplete responses returned by the backend layer",
+		stats.UnitDimensionless,
+	)
+
+	// KeyEndpoint is the endpoint pattern of the pipe and backend stats
+	KeyEndpoint = tag.MustNewKey("krakend_endpoint")
+	// KeyBackend is the url pattern of the backend stats
+	KeyBackend = tag.MustNewKey("krakend_backend")
+	// KeyErrorClass classifies the errors returned by the pipe and backend layers
+	KeyErrorClass = tag.MustNewKey("krakend_error_class")
+
+	PipeLatencyView = &view.View{
+		Name:        "krakend.io/proxy/latency",
+		Description: "Latency distribution of the pipe layer by endpoint",
+		TagKeys:     []tag.Key{KeyEndpoint},
+		Measure:     PipeLatency,
+		Aggregation: ochttp.DefaultLatencyDistribution,
+	}
+	PipeErrorsView = &view.View{
+		Name:        "krakend.io/proxy/errors",
+		Description: "Count of errors of the pipe layer by endpoint and error class",
+		TagKeys:     []tag.Key{KeyEndpoint, KeyErrorClass},
+		Measure:     PipeErrors,
+		Aggregation: view.Count(),
+	}
+	PipeCanceledView = &view.View{
+		Name:        "krakend.io/proxy/canceled",
+		Description: "Count of canceled requests of the pipe layer by endpoint",
+		TagKeys:     []tag.Key{KeyEndpoint},
+		Measure:     PipeCanceled,
+		Aggregation: view.Count(),
+	}
+	PipeIncompleteView = &view.View{
+		Name:        "krakend.io/proxy/incomplete",
+		Description: "Count of incomplete responses of the pipe layer by endpoint",
+		TagKeys:     []tag.Key{KeyEndpoint},
+		Measure:     PipeIncomplete,
+		Aggregation: view.Count(),
+	}
+
+	BackendLatencyView = &view.View{
+		Name:        "krakend.io/backend/latency",
+		Description: "Latency distribution of the backend layer by endpoint and backend",
+		TagKeys:     []tag.Key{KeyEndpoint, KeyBackend},
+		Measure:     BackendLatency,
+		Aggregation: ochttp.DefaultLatencyDistribution,
+	}
+	BackendErrorsView = &view.View{
+		Name:        "krakend.io/backend/errors",
+		Description: "Count of errors of the backend layer by endpoint, backend and error class",
+		TagKeys:     []tag.Key{KeyEndpoint, KeyBackend, KeyErrorClass},
+		Measure:     BackendErrors,
+		Aggregation: view.Count(),
+	}
+	BackendCanceledView = &view.View{
+		Name:        "krakend.io/backend/canceled",
+		Description: "Count of canceled requests of the backend layer by endpoint and backend",
+		TagKeys:     []tag.Key{KeyEndpoint, KeyBackend},
+		Measure:     BackendCanceled,
+		Aggregation: view.Count(),
+	}
+	BackendIncompleteView = &view.View{
+		Name:        "krakend.io/backend/incomplete",
+		Description: "Count of incomplete responses of the backend layer by endpoint and backend",
+		TagKeys:     []tag.Key{KeyEndpoint, KeyBackend},
+		Measure:     BackendIncomplete,
+		Aggregation: view.Count(),
+	}
+)
+
+// layerStats records the stats of a pipe or a backend
+type layerStats struct {
+	tags       []tag.Mutator
+	latency    *stats.Float64Measure
+	errors     *stats.Int64Measure
+	canceled   *stats.Int64Measure
+	incomplete *stats.Int64Measure
+}
+
+func newPipeStats(endpoint string) *layerStats {
+	return &layerStats{
+		tags:       []tag.Mutator{tag.Upsert(KeyEndpoint, endpoint)},
+		latency:    PipeLatency,
+		errors:     PipeErrors,
+		canceled:   PipeCanceled,
+		incomplete: PipeIncomplete,
+	}
+}
+
+func newBackendStats(endpoint, backend string) *layerStats {
+	return &layerStats{
+		tags:       []tag.Mutator{tag.Upsert(KeyEndpoint, endpoint), tag.Upsert(KeyBackend, backend)},
+		latency:    BackendLatency,
+		errors:     BackendErrors,
+		canceled:   BackendCanceled,
+		incomplete: BackendIncomplete,
+	}
+}
+
+func (s *layerStats) record(ctx context.Context, start time.Time, resp *proxy.Response, err error) {
+	ms := []stats.Measurement{
+		s.latency.M(float64(time.Since(start)) / float64(time.Millisecond)),
+	}
+	if resp != nil && !resp.IsComplete {
+		ms = append(ms, s.incomplete.M(1))
+	}
+	stats.RecordWithTags(ctx, s.tags, ms...)
+
+	if err == nil {
+		return
+	}
+	class := errorClass(err)
+	if class == errorClassCanceled {
+		stats.RecordWithTags(ctx, s.tags, s.canceled.M(1))
+		return
+	}
+	stats.RecordWithTags(ctx, append(s.tags, tag.Upsert(KeyErrorClass, class)), s.errors.M(1))
+}
@@ -0,0 +1,88 @@
+package opencensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/luraproject/lura/v2/proxy"
+	"go.opencensus.io/stats/view"
+)
+
+func TestMiddleware_stats(t *testing.T) {
+	views := []*view.View{PipeLatencyView, PipeErrorsView, PipeCanceledView, PipeIncompleteView}
+	if err := view.Register(views...); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(views...)
+
+	for i, tc := range []struct {
+		resp *proxy.Response
+		err  error
+	}{
+		{resp: &proxy.Response{IsComplete: true}},
+		{resp: &proxy.Response{IsComplete: false}},
+		{err: context.Canceled},
+		{err: fmt.Errorf("wrapped: %w", context.DeadlineExceeded)},
+		{err: errors.New("boom")},
+	} {
+		p := middleware("pipe", newPipeStats("/foo"), nil)(func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+			return tc.resp, tc.err
+		})
+		if _, err := p(context.Background(), &proxy.Request{}); err != tc.err {
+			t.Errorf("tc-%d: unexpected error: %v", i, err)
+		}
+	}
+
+	for name, expected := range map[string]int{
+		PipeLatencyView.Name:    1,
+		PipeErrorsView.Name:     2,
+		PipeCanceledView.Name:   1,
+		PipeIncompleteView.Name: 1,
+	} {
+		rows, err := view.RetrieveData(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(rows) != expected {
+			t.Errorf("%s: unexpected number of rows. have %d, want %d", name, len(rows), expected)
+		}
+	}
+
+	rows, _ := view.RetrieveData(PipeLatencyView.Name)
+	if d, ok := rows[0].Data.(*view.DistributionData); !ok || d.Count != 5 {
+		t.Errorf("unexpected latency data: %v", rows[0].Data)
+	}
+}
+
+func TestMiddleware_namedStats(t *testing.T) {
+	defer enabledLayers.Store(nil)
+	enabledLayers.Store(&EnabledLayers{Pipe: true})
+
+	if err := view.Register(PipeLatencyView); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(PipeLatencyView)
+
+	p := Middleware("custom")(func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		return &proxy.Response{IsComplete: true}, nil
+	})
+	if _, err := p(context.Background(), &proxy.Request{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := view.RetrieveData(PipeLatencyView.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || len(rows[0].Tags) == 0 {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+	for _, tg := range rows[0].Tags {
+		if tg.Key == KeyEndpoint && tg.Value != "custom" {
+			t.Errorf("unexpected endpoint tag: %s", tg.Value)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package opencensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/luraproject/lura/v2/transport/http/client"
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/trace"
+)
+
+func TestErrorClass(t *testing.T) {
+	for i, tc := range []struct {
+		err      error
+		expected string
+	}{
+		{err: context.Canceled, expected: errorClassCanceled},
+		{err: fmt.Errorf("x: %w", context.Canceled), expected: errorClassCanceled},
+		{err: context.DeadlineExceeded, expected: errorClassDeadlineExceeded},
+		{err: client.HTTPResponseError{Code: http.StatusNotFound}, expected: "http_4xx"},
+		{err: client.NamedHTTPResponseError{HTTPResponseError: client.HTTPResponseError{Code: 503}}, expected: "http_5xx"},
+		{err: fmt.Errorf("x: %w", client.HTTPResponseError{Code: 502}), expected: "http_5xx"},
+		{err: client.HTTPResponseError{}, expected: errorClassOther},
+		{err: errors.New("boom"), expected: errorClassOther},
+	} {
+		if class := errorClass(tc.err); class != tc.expected {
+			t.Errorf("tc-%d: have %s, want %s", i, class, tc.expected)
+		}
+	}
+}
+
+func TestMiddleware_spanStatus(t *testing.T) {
+	for i, tc := range []struct {
+		resp       *proxy.Response
+		err        error
+		status     int32
+		statusCode int64
+		errorType  string
+	}{
+		{
+			resp:       &proxy.Response{IsComplete: true, Metadata: proxy.Metadata{StatusCode: 200}},
+			status:     trace.StatusCodeOK,
+			statusCode: 200,
+		},
+		{
+			resp:   &proxy.Response{IsComplete: true},
+			status: trace.StatusCodeOK,
+		},
+		{
+			err:       context.Canceled,
+			status:    trace.StatusCodeCancelled,
+			errorType: errorClassCanceled,
+		},
+		{
+			err:       fmt.Errorf("timeout: %w", context.DeadlineExceeded),
+			status:    trace.StatusCodeDeadlineExceeded,
+			errorType: errorClassDeadlineExceeded,
+		},
+		{
+			err:        client.HTTPResponseError{Code: http.StatusServiceUnavailable, Msg: "unavailable"},
+			status:     trace.StatusCodeUnavailable,
+			statusCode: http.StatusServiceUnavailable,
+			errorType:  "http_5xx",
+		},
+		{
+			err:       errors.New("boom"),
+			status:    trace.StatusCodeUnknown,
+			errorType: errorClassOther,
+		},
+	} {
+		rec := &spanRecorder{}
+		trace.RegisterExporter(rec)
+		p := middleware("test", nil, nil)(func(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+			return tc.resp, tc.err
+		})
+		ctx, parent := trace.StartSpan(context.Background(), "parent", trace.WithSampler(trace.AlwaysSample()))
+		p(ctx, &proxy.Request{})
+		parent.End()
+		trace.UnregisterExporter(rec)
+
+		spans := rec.spans
+		if len(spans) != 2 {
+			t.Errorf("tc-%d: unexpected number of spans: %d", i, len(spans))
+			continue
+		}
+		span := spans[0]
+		if span.Status.Code != tc.status {
+			t.Errorf("tc-%d: unexpected status. have %d, want %d", i, span.Status.Code, tc.status)
+		}
+		if code, _ := span.Attributes[ochttp.StatusCodeAttribute].(int64); code != tc.statusCode {
+			t.Errorf("tc-%d: unexpected status code. have %d, want %d", i, code, tc.statusCode)
+		}
+		if errorType, _ := span.Attributes[ErrorTypeAttribute].(string); errorType != tc.errorType {
+			t.Errorf("tc-%d: unexpected error type. have %s, want %s", i, errorType, tc.errorType)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package opencensus
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/luraproject/lura/v2/config"
+)
+
+// TrustedParentsConfig defines the requests allowed to continue a remote trace when they hit a
+// public endpoint. A request is trusted if it comes from any of the networks or if it carries
+// all the headers.
+type TrustedParentsConfig struct {
+	// CIDRs lists the trusted networks. Only the address of the peer is checked
+	CIDRs []string `json:"cidrs"`
+	// Headers maps header names to their expected values. An empty value only checks the
+	// presence of the header
+	Headers map[string]string `json:"headers"`
+}
+
+var currentParentTrust atomic.Pointer[parentTrust]
+
+type parentTrust struct {
+	public   bool
+	networks []*net.IPNet
+	headers  map[string]string
+}
+
+func newParentTrust(public bool, cfg *TrustedParentsConfig) (*parentTrust, error) {
+	pt := &parentTrust{public: public}
+	if cfg == nil {
+		return pt, nil
+	}
+	networks, err := parseCIDRs(cfg.CIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("trusted parents: %w", err)
+	}
+	pt.networks = networks
+	pt.headers = cfg.Headers
+	return pt, nil
+}
+
+// IsPublicEndpoint reports if the endpoint is reachable by untrusted clients, so the remote
+// parents of its requests must be linked instead of continued
+func IsPublicEndpoint(cfg *config.EndpointConfig) bool {
+	if extraCfg, err := parseEndpointConfig(cfg); err == nil && extraCfg.PublicEndpoint != nil {
+		return *extraCfg.PublicEndpoint
+	}
+	pt := currentParentTrust.Load()
+	return pt != nil && pt.public
+}
+
+// TrustRemoteParent reports if the remote parent of a request to a public endpoint should be
+// continued, according to the trusted parents configuration
+func TrustRemoteParent(r *http.Request) bool {
+	pt := currentParentTrust.Load()
+	if pt == nil {
+		return false
+	}
+	if len(pt.networks) > 0 && containsIP(pt.networks, remoteIP(r)) {
+		return true
+	}
+	return len(pt.headers) > 0 && matchHeaders(r, pt.headers)
+}
@@ -0,0 +1,77 @@
+package opencensus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/luraproject/lura/v2/config"
+)
+
+func TestIsPublicEndpoint(t *testing.T) {
+	defer currentParentTrust.Store(nil)
+
+	endpoint := func(public bool) *config.EndpointConfig {
+		return &config.EndpointConfig{
+			ExtraConfig: config.ExtraConfig{
+				Namespace: map[string]interface{}{"public_endpoint": public},
+			},
+		}
+	}
+
+	for i, tc := range []struct {
+		global   bool
+		endpoint *config.EndpointConfig
+		expected bool
+	}{
+		{endpoint: &config.EndpointConfig{}},
+		{global: true, endpoint: &config.EndpointConfig{}, expected: true},
+		{global: true, endpoint: endpoint(false)},
+		{global: false, endpoint: endpoint(true), expected: true},
+	} {
+		pt, _ := newParentTrust(tc.global, nil)
+		currentParentTrust.Store(pt)
+		if res := IsPublicEndpoint(tc.endpoint); res != tc.expected {
+			t.Errorf("tc-%d: have %v, want %v", i, res, tc.expected)
+		}
+	}
+}
+
+func TestTrustRemoteParent(t *testing.T) {
+	defer currentParentTrust.Store(nil)
+
+	pt, err := newParentTrust(true, &TrustedParentsConfig{
+		CIDRs:   []string{"10.0.0.0/8"},
+		Headers: map[string]string{"X-Internal": "yes", "X-Mesh": ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentParentTrust.Store(pt)
+
+	for i, tc := range []struct {
+		remoteAddr string
+		headers    map[string]string
+		expected   bool
+	}{
+		{remoteAddr: "10.1.2.3:1234", expected: true},
+		{remoteAddr: "192.168.1.1:1234"},
+		{remoteAddr: "192.168.1.1:1234", headers: map[string]string{"X-Forwarded-For": "10.1.2.3"}},
+		{remoteAddr: "192.168.1.1:1234", headers: map[string]string{"X-Internal": "yes"}},
+		{remoteAddr: "192.168.1.1:1234", headers: map[string]string{"X-Internal": "no", "X-Mesh": "1"}},
+		{remoteAddr: "192.168.1.1:1234", headers: map[string]string{"X-Internal": "yes", "X-Mesh": "1"}, expected: true},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		r.RemoteAddr = tc.remoteAddr
+		for k, v := range tc.headers {
+			r.Header.Set(k, v)
+		}
+		if res := TrustRemoteParent(r); res != tc.expected {
+			t.Errorf("tc-%d: have %v, want %v", i, res, tc.expected)
+		}
+	}
+
+	if _, err := newParentTrust(true, &TrustedParentsConfig{CIDRs: []string{"10.0.0.0"}}); err == nil {
+		t.Error("error expected for bad networks")
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"github.com/luraproject/lura/v2/proxy"
 	krakendgin "github.com/luraproject/lura/v2/router/gin"
 	"go.opencensus.io/plugin/ochttp"
-	"go.opencensus.io/plugin/ochttp/propagation/b3"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/tag"
 	"go.opencensus.io/trace"
@@ -29,15 +28,18 @@ func HandlerFunc(cfg *config.EndpointConfig, next gin.HandlerFunc, prop propagat
 	if !opencensus.IsRouterEnabled() {
 		return next
 	}
-	if prop == nil {
-		prop = &b3.HTTPFormat{}
-	}
 	pathExtractor := opencensus.GetAggregatedPathForMetrics(cfg)
 	h := &handler{
-		name:        cfg.Endpoint,
-		propagation: prop,
-		Handler:     next,
+		route:            cfg.Endpoint,
+		formatSpanName:   opencensus.GetSpanNameForEndpoint(cfg),
+		traceResponse:    opencensus.GetTraceResponseWriter(cfg),
+		propagation:      prop,
+		Handler:          next,
+		IsPublicEndpoint: opencensus.IsPublicEndpoint(cfg),
+		exclusions:       opencensus.GetExclusionsForEndpoint(cfg, opencensus.LayerRouter),
+		spanHeaders:      opencensus.GetSpanHeadersForEndpoint(cfg),
 		StartOptions: trace.StartOptions{
+			Sampler:  opencensus.GetSamplerForEndpoint(cfg),
 			SpanKind: trace.SpanKindServer,
 		},
 		tags: []tagGenerator{
@@ -47,50 +49,84 @@ func HandlerFunc(cfg *config.EndpointConfig, next gin.HandlerFunc, prop propagat
 			func(r *http.Request) tag.Mutator { return tag.Upsert(ochttp.Path, pathExtractor(r)) },
 		},
 	}
+	for _, tg := range opencensus.HeaderTagGenerators() {
+		h.tags = append(h.tags, tg)
+	}
 	return h.HandlerFunc
 }
 
 type handler struct {
-	name             string
+	route            string
+	formatSpanName   func(*http.Request) string
+	traceResponse    func(http.Header, trace.SpanContext)
 	propagation      propagation.HTTPFormat
 	Handler          gin.HandlerFunc
 	StartOptions     trace.StartOptions
 	IsPublicEndpoint bool
 	tags             []tagGenerator
+	exclusions       func(method, path string) opencensus.Exclusion
+	spanHeaders      *opencensus.SpanHeaders
 }
 
 type tagGenerator func(*http.Request) tag.Mutator
 
 func (h *handler) HandlerFunc(c *gin.Context) {
-	var traceEnd, statsEnd func()
-	c.Request, traceEnd = h.startTrace(c.Writer, c.Request)
-	c.Writer, statsEnd = h.startStats(c.Writer, c.Request)
+	var ex opencensus.Exclusion
+	if h.exclusions != nil {
+		ex = h.exclusions(c.Request.Method, c.Request.URL.Path)
+	}
+
+	var span *trace.Span
+	statsEnd := func() {}
+	c.Request = opencensus.ExtractBaggage(c.Request)
+	c.Request, span = h.startTrace(c.Request, ex.Traces)
+	if !ex.Stats {
+		c.Writer, statsEnd = h.startStats(c.Writer, c.Request)
+	}
 
-	c.Set(opencensus.ContextKey, trace.FromContext(c.Request.Context()))
+	c.Set(opencensus.ContextKey, span)
+	if h.traceResponse != nil {
+		h.traceResponse(c.Writer.Header(), span.SpanContext())
+	}
 	h.Handler(c)
 
 	statsEnd()
-	traceEnd()
+	h.endTrace(span, c)
 }
 
-func (h *handler) startTrace(_ gin.ResponseWriter, r *http.Request) (*http.Request, func()) {
+// startTrace starts the server span of the request. The span of an excluded request is never
+// sampled, so the pipe and the backends do not start new traces on their own
+func (h *handler) startTrace(r *http.Request, excluded bool) (*http.Request, *trace.Span) {
 	ctx := r.Context()
 	var span *trace.Span
 	sc, ok := h.extractSpanContext(r)
+	name := h.formatSpanName(r)
+
+	sampler := h.StartOptions.Sampler
+	if s := opencensus.SamplerForRequest(r); s != nil {
+		sampler = s
+	}
+	forced := !excluded && opencensus.ForceSampling(r)
+	if forced {
+		sampler = trace.AlwaysSample()
+	}
+	if excluded {
+		sampler = trace.NeverSample()
+	}
 
-	if ok && !h.IsPublicEndpoint {
+	if ok && (!h.IsPublicEndpoint || opencensus.TrustRemoteParent(r)) {
 		ctx, span = trace.StartSpanWithRemoteParent(
 			ctx,
-			h.name,
+			name,
 			sc,
-			trace.WithSampler(h.StartOptions.Sampler),
+			trace.WithSampler(sampler),
 			trace.WithSpanKind(h.StartOptions.SpanKind),
 		)
 	} else {
 		ctx, span = trace.StartSpan(
 			ctx,
-			h.name,
-			trace.WithSampler(h.StartOptions.Sampler),
+			name,
+			trace.WithSampler(sampler),
 			trace.WithSpanKind(h.StartOptions.SpanKind),
 		)
 
@@ -105,10 +141,45 @@ func (h *handler) startTrace(_ gin.ResponseWriter, r *http.Request) (*http.Reque
 	}
 
 	span.AddAttributes(opencensus.RequestAttrs(r)...)
-	return r.WithContext(ctx), span.End
+	span.AddAttributes(h.spanHeaders.RequestAttrs(r.Header)...)
+	if forced {
+		span.AddAttributes(trace.BoolAttribute(opencensus.ForcedSamplingAttribute, true))
+	}
+	return r.WithContext(ctx), span
+}
+
+func (h *handler) endTrace(span *trace.Span, c *gin.Context) {
+	defer span.End()
+	if !span.IsRecordingEvents() {
+		return
+	}
+
+	status := c.Writer.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	size := c.Writer.Size()
+	if size < 0 {
+		size = 0
+	}
+	span.AddAttributes(
+		trace.Int64Attribute(ochttp.StatusCodeAttribute, int64(status)),
+		trace.Int64Attribute(opencensus.ResponseSizeAttribute, int64(size)),
+		trace.StringAttribute(opencensus.RouteAttribute, h.route),
+	)
+	span.AddAttributes(h.spanHeaders.ResponseAttrs(c.Writer.Header())...)
+	for _, err := range c.Errors {
+		span.Annotate(nil, err.Error())
+	}
+	span.SetStatus(opencensus.TraceStatus(status, ""))
 }
 
+// extractSpanContext uses the current propagation formats unless the handler got its own ones,
+// so the router keeps reading what the backends inject after a reload
 func (h *handler) extractSpanContext(r *http.Request) (trace.SpanContext, bool) {
+	if h.propagation == nil {
+		return opencensus.PropagationFormat().SpanContextFromRequest(r)
+	}
 	return h.propagation.SpanContextFromRequest(r)
 }
 
@@ -118,6 +189,7 @@ func (h *handler) startStats(w gin.ResponseWriter, r *http.Request) (gin.Respons
 		tags[i] = t(r)
 	}
 	ctx, _ := tag.New(r.Context(), tags...)
+	ctx = opencensus.LimitTagCardinality(ctx, opencensus.ServerMeasures...)
 	track := &trackingResponseWriter{
 		start:          time.Now(),
 		ctx:            ctx,
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
@@ -17,6 +18,7 @@ import (
 	"github.com/luraproject/lura/v2/proxy"
 	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/stats/view"
+	"go.opencensus.io/trace"
 )
 
 var (
@@ -188,3 +190,128 @@ func registerModule() error {
 
 	return opencensus.Register(context.Background(), config.ServiceConfig{ExtraConfig: extraCfg})
 }
+
+func TestHandlerFunc_responseAttributes(t *testing.T) {
+	exporter := &spanExporter{}
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	cfg := &config.EndpointConfig{
+		Endpoint: "/users/:id",
+		ExtraConfig: config.ExtraConfig{
+			opencensus.Namespace: map[string]interface{}{"sample_rate": 100},
+		},
+	}
+	hf := HandlerFunc(cfg, func(c *gin.Context) {
+		c.Error(errors.New("first error"))
+		c.Error(errors.New("second error"))
+		c.String(http.StatusServiceUnavailable, "unavailable")
+	}, nil)
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/users/:id", hf)
+
+	req, _ := http.NewRequest("GET", "/users/42", http.NoBody)
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(exporter.spans) != 1 {
+		t.Fatalf("unexpected number of spans: %d", len(exporter.spans))
+	}
+	span := exporter.spans[0]
+	if code := span.Attributes[ochttp.StatusCodeAttribute]; code != int64(http.StatusServiceUnavailable) {
+		t.Errorf("unexpected status code: %v", code)
+	}
+	if size := span.Attributes[opencensus.ResponseSizeAttribute]; size != int64(len("unavailable")) {
+		t.Errorf("unexpected response size: %v", size)
+	}
+	if route := span.Attributes[opencensus.RouteAttribute]; route != "/users/:id" {
+		t.Errorf("unexpected route: %v", route)
+	}
+	if span.Status.Code != trace.StatusCodeUnavailable {
+		t.Errorf("unexpected status: %v", span.Status)
+	}
+	if len(span.Annotations) != 2 || span.Annotations[0].Message != "first error" || span.Annotations[1].Message != "second error" {
+		t.Errorf("unexpected annotations: %v", span.Annotations)
+	}
+}
+
+type spanExporter struct {
+	spans []*trace.SpanData
+}
+
+func (e *spanExporter) ExportSpan(s *trace.SpanData) {
+	e.spans = append(e.spans, s)
+}
+
+func TestHandlerFunc_publicEndpoint(t *testing.T) {
+	exporter := &spanExporter{}
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	cfg := &config.EndpointConfig{
+		Endpoint: "/public",
+		ExtraConfig: config.ExtraConfig{
+			opencensus.Namespace: map[string]interface{}{"sample_rate": 100, "public_endpoint": true},
+		},
+	}
+	hf := HandlerFunc(cfg, func(c *gin.Context) { c.Status(http.StatusOK) }, nil)
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/public", hf)
+
+	req, _ := http.NewRequest("GET", "/public", http.NoBody)
+	req.Header.Set("X-B3-SpanId", "48656c6c6f")
+	req.Header.Set("X-B3-TraceId", "5370616e")
+	req.Header.Set("X-B3-Sampled", "1")
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(exporter.spans) != 1 {
+		t.Fatalf("unexpected number of spans: %d", len(exporter.spans))
+	}
+	span := exporter.spans[0]
+	if span.HasRemoteParent || span.ParentSpanID != (trace.SpanID{}) {
+		t.Errorf("the untrusted parent should not be continued: %+v", span.SpanContext)
+	}
+	if len(span.Links) != 1 || span.Links[0].Type != trace.LinkTypeChild {
+		t.Errorf("the untrusted parent should be linked: %v", span.Links)
+	}
+}
+
+func TestHandlerFunc_exclusions(t *testing.T) {
+	exporter := &spanExporter{}
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	cfg := &config.EndpointConfig{
+		Endpoint: "/__stats",
+		ExtraConfig: config.ExtraConfig{
+			opencensus.Namespace: map[string]interface{}{
+				"sample_rate": 100,
+				"exclusions":  []interface{}{map[string]interface{}{"path": "/__stats"}},
+			},
+		},
+	}
+	var traced bool
+	hf := HandlerFunc(cfg, func(c *gin.Context) {
+		span, _ := c.Get(opencensus.ContextKey)
+		traced = span.(*trace.Span).IsRecordingEvents()
+		c.Status(http.StatusOK)
+	}, nil)
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/__stats", hf)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/__stats", http.NoBody)
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+	if traced || len(exporter.spans) != 0 {
+		t.Errorf("the excluded request should not be traced: %d spans", len(exporter.spans))
+	}
+}
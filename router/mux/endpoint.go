@@ -2,12 +2,17 @@ package mux
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	opencensus "github.com/krakend/krakend-opencensus/v2"
 	"github.com/luraproject/lura/v2/config"
 	"github.com/luraproject/lura/v2/proxy"
 	"github.com/luraproject/lura/v2/router/mux"
 	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
 )
 
 func New(hf mux.HandlerFactory) mux.HandlerFactory {
@@ -15,15 +20,207 @@ func New(hf mux.HandlerFactory) mux.HandlerFactory {
 		return hf
 	}
 	return func(cfg *config.EndpointConfig, p proxy.Proxy) http.HandlerFunc {
-		handler := ochttp.Handler{Handler: tagAggregationMiddleware(hf(cfg, p), cfg)}
-		return handler.ServeHTTP
+		exclusions := opencensus.GetExclusionsForEndpoint(cfg, opencensus.LayerRouter)
+		pathExtractor := opencensus.GetAggregatedPathForMetrics(cfg)
+		h := &handler{
+			Handler:          traceResponseMiddleware(spanHeadersMiddleware(forcedSamplingMiddleware(hf(cfg, p)), cfg), cfg),
+			formatSpanName:   opencensus.GetSpanNameForEndpoint(cfg),
+			getStartOptions:  getStartOptions(trace.StartOptions{Sampler: opencensus.GetSamplerForEndpoint(cfg)}, exclusions),
+			isPublicEndpoint: opencensus.IsPublicEndpoint(cfg),
+			exclusions:       exclusions,
+			tags: []tagGenerator{
+				func(r *http.Request) tag.Mutator { return tag.Upsert(ochttp.KeyServerRoute, pathExtractor(r)) },
+				func(r *http.Request) tag.Mutator { return tag.Upsert(ochttp.Host, r.Host) },
+				func(r *http.Request) tag.Mutator { return tag.Upsert(ochttp.Path, r.URL.Path) },
+				func(r *http.Request) tag.Mutator { return tag.Upsert(ochttp.Method, r.Method) },
+			},
+		}
+		return baggageMiddleware(headerTagsMiddleware(h)).ServeHTTP
 	}
 }
 
-func tagAggregationMiddleware(next http.Handler, cfg *config.EndpointConfig) http.Handler {
-	pathExtractor := opencensus.GetAggregatedPathForMetrics(cfg)
+// handler traces the requests and records the ochttp server stats. It replaces the
+// ochttp.Handler, so the stats can be skipped for the excluded requests and their tag
+// values can be capped right before being recorded
+type handler struct {
+	Handler          http.Handler
+	formatSpanName   func(*http.Request) string
+	getStartOptions  func(*http.Request) trace.StartOptions
+	isPublicEndpoint bool
+	exclusions       func(method, path string) opencensus.Exclusion
+	tags             []tagGenerator
+}
+
+type tagGenerator func(*http.Request) tag.Mutator
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, span := h.startTrace(r)
+	defer span.End()
+
+	track := &trackingResponseWriter{ResponseWriter: w}
+	statsEnd := func(*trackingResponseWriter) {}
+	if h.exclusions == nil || !h.exclusions(r.Method, r.URL.Path).Stats {
+		statsEnd = h.startStats(r)
+	}
+	defer func() {
+		status := track.status()
+		span.SetStatus(opencensus.TraceStatus(status, ""))
+		span.AddAttributes(trace.Int64Attribute(ochttp.StatusCodeAttribute, int64(status)))
+		statsEnd(track)
+	}()
+
+	h.Handler.ServeHTTP(track, r)
+}
+
+// startTrace starts the server span of the request. Like the ochttp handler, it does not trace
+// the canonical health checks, so the returned span may be nil
+func (h *handler) startTrace(r *http.Request) (*http.Request, *trace.Span) {
+	if isHealthEndpoint(r.URL.Path) {
+		return r, nil
+	}
+	ctx := r.Context()
+	name := h.formatSpanName(r)
+	opts := []trace.StartOption{
+		trace.WithSampler(h.getStartOptions(r).Sampler),
+		trace.WithSpanKind(trace.SpanKindServer),
+	}
+	var span *trace.Span
+	// the propagation formats are read on every request, so they follow the reloads
+	sc, ok := opencensus.PropagationFormat().SpanContextFromRequest(r)
+	if ok && (!h.isPublicEndpoint || opencensus.TrustRemoteParent(r)) {
+		ctx, span = trace.StartSpanWithRemoteParent(ctx, name, sc, opts...)
+	} else {
+		ctx, span = trace.StartSpan(ctx, name, opts...)
+		if ok {
+			span.AddLink(trace.Link{TraceID: sc.TraceID, SpanID: sc.SpanID, Type: trace.LinkTypeChild})
+		}
+	}
+	span.AddAttributes(opencensus.RequestAttrs(r)...)
+	if r.Body != nil && r.ContentLength > 0 {
+		span.AddMessageReceiveEvent(0, r.ContentLength, -1)
+	}
+	return r.WithContext(ctx), span
+}
+
+// isHealthEndpoint reports the health check paths skipped by the ochttp handler
+func isHealthEndpoint(path string) bool {
+	return path == "/healthz" || path == "/_ah/health"
+}
+
+// startStats records the request and returns the function recording the response. The
+// tags are capped by the cardinality limiter before recording anything
+func (h *handler) startStats(r *http.Request) func(*trackingResponseWriter) {
+	tags := make([]tag.Mutator, len(h.tags))
+	for i, t := range h.tags {
+		tags[i] = t(r)
+	}
+	ctx, _ := tag.New(r.Context(), tags...)
+	ctx = opencensus.LimitTagCardinality(ctx, opencensus.ServerMeasures...)
+
+	start := time.Now()
+	reqSize := int64(-1)
+	if r.Body != nil {
+		reqSize = r.ContentLength
+		if reqSize < 0 {
+			reqSize = 0
+		}
+	}
+	stats.Record(ctx, ochttp.ServerRequestCount.M(1))
+
+	return func(track *trackingResponseWriter) {
+		m := []stats.Measurement{
+			ochttp.ServerLatency.M(float64(time.Since(start)) / float64(time.Millisecond)),
+			ochttp.ServerResponseBytes.M(track.size),
+		}
+		if reqSize >= 0 {
+			m = append(m, ochttp.ServerRequestBytes.M(reqSize))
+		}
+		ctx, _ := tag.New(ctx, tag.Upsert(ochttp.StatusCode, strconv.Itoa(track.status())))
+		stats.Record(ctx, m...)
+	}
+}
+
+// baggageMiddleware adds the propagated baggage to the tags of the request context before
+// the handler records its stats
+func baggageMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ochttp.SetRoute(r.Context(), pathExtractor(r))
+		next.ServeHTTP(w, opencensus.ExtractBaggage(r))
+	})
+}
+
+// headerTagsMiddleware adds the header tags to the request context before the handler
+// records its stats
+func headerTagsMiddleware(next http.Handler) http.Handler {
+	headerTags := opencensus.HeaderTagGenerators()
+	if len(headerTags) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tags := make([]tag.Mutator, len(headerTags))
+		for i, tg := range headerTags {
+			tags[i] = tg(r)
+		}
+		ctx, _ := tag.New(r.Context(), tags...)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func forcedSamplingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if span := trace.FromContext(r.Context()); span != nil && opencensus.ForceSampling(r) {
+			span.AddAttributes(trace.BoolAttribute(opencensus.ForcedSamplingAttribute, true))
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// traceResponseMiddleware writes the trace of the request into the response headers
+func traceResponseMiddleware(next http.Handler, cfg *config.EndpointConfig) http.Handler {
+	write := opencensus.GetTraceResponseWriter(cfg)
+	if write == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if span := trace.FromContext(r.Context()); span != nil {
+			write(w.Header(), span.SpanContext())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// spanHeadersMiddleware records the allowlisted request and response headers in the span
+// started by the handler
+func spanHeadersMiddleware(next http.Handler, cfg *config.EndpointConfig) http.Handler {
+	sh := opencensus.GetSpanHeadersForEndpoint(cfg)
+	if sh == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.FromContext(r.Context())
+		if span == nil || !span.IsRecordingEvents() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		span.AddAttributes(sh.RequestAttrs(r.Header)...)
+		next.ServeHTTP(w, r)
+		span.AddAttributes(sh.ResponseAttrs(w.Header())...)
+	})
+}
+
+func getStartOptions(defaults trace.StartOptions, exclusions func(method, path string) opencensus.Exclusion) func(*http.Request) trace.StartOptions {
+	return func(r *http.Request) trace.StartOptions {
+		opts := defaults
+		if exclusions != nil && exclusions(r.Method, r.URL.Path).Traces {
+			// the span is still created, so the pipe and the backends do not start new traces
+			opts.Sampler = trace.NeverSample()
+			return opts
+		}
+		if s := opencensus.SamplerForRequest(r); s != nil {
+			opts.Sampler = s
+		}
+		if opencensus.ForceSampling(r) {
+			opts.Sampler = trace.AlwaysSample()
+		}
+		return opts
+	}
+}
@@ -16,6 +16,8 @@ import (
 	"github.com/luraproject/lura/v2/proxy"
 	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
 )
 
 var (
@@ -112,6 +114,97 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNew_cardinalityLimit(t *testing.T) {
+	pathCount := &view.View{
+		Name:        "krakend.io/test/mux/request_count_by_path",
+		Measure:     ochttp.ServerRequestCount,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{ochttp.Path},
+	}
+	limitedCfg := map[string]interface{}{
+		opencensus.Namespace: map[string]interface{}{
+			"enabled_layers":    map[string]interface{}{"router": true},
+			"cardinality_limit": map[string]interface{}{"max_values": 3},
+		},
+	}
+	if err := opencensus.Reload(context.Background(), config.ServiceConfig{ExtraConfig: limitedCfg}, pathCount); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := opencensus.Reload(context.Background(), config.ServiceConfig{ExtraConfig: extraCfg}); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	hf := New(func(_ *config.EndpointConfig, _ proxy.Proxy) http.HandlerFunc {
+		return httpHandler(http.StatusOK, 10).ServeHTTP
+	})
+	h := hf(&config.EndpointConfig{
+		Endpoint: "/{id}",
+		ExtraConfig: config.ExtraConfig{
+			opencensus.Namespace: map[string]interface{}{"path_aggregation": "off"},
+		},
+	}, proxy.NoopProxy)
+
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/%d", i), http.NoBody)
+		h(httptest.NewRecorder(), req)
+	}
+
+	rows, err := view.RetrieveData(pathCount.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int64{}
+	for _, row := range rows {
+		counts[row.Tags[0].Value] = row.Data.(*view.CountData).Value
+	}
+	if len(counts) != 4 {
+		t.Errorf("unexpected paths: %v", counts)
+	}
+	if counts["overflow"] != 7 {
+		t.Errorf("unexpected overflow count: %v", counts)
+	}
+}
+
+func TestNew_healthEndpoint(t *testing.T) {
+	exporter := &spanExporter{}
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	hf := New(func(_ *config.EndpointConfig, _ proxy.Proxy) http.HandlerFunc {
+		return httpHandler(http.StatusOK, 10).ServeHTTP
+	})
+	h := hf(&config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			opencensus.Namespace: map[string]interface{}{"sample_rate": 100},
+		},
+	}, proxy.NoopProxy)
+
+	for _, p := range []string{"/healthz", "/_ah/health", "/users"} {
+		req, _ := http.NewRequest("GET", p, http.NoBody)
+		w := httptest.NewRecorder()
+		h(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: unexpected status code: %d", p, w.Code)
+		}
+	}
+
+	if len(exporter.spans) != 1 || exporter.spans[0].Attributes["http.path"] != "/users" {
+		for _, s := range exporter.spans {
+			t.Errorf("unexpected span: %s %v", s.Name, s.Attributes)
+		}
+	}
+}
+
+type spanExporter struct {
+	spans []*trace.SpanData
+}
+
+func (e *spanExporter) ExportSpan(s *trace.SpanData) {
+	e.spans = append(e.spans, s)
+}
+
 func httpHandler(statusCode, respSize int) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(statusCode)
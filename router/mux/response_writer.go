@@ -0,0 +1,65 @@
+package mux
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// trackingResponseWriter keeps the status code and the size of the response. It exposes the
+// Flusher and Hijacker interfaces of the wrapped writer, so streaming and upgrades keep working
+type trackingResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	size       int64
+}
+
+var (
+	_ http.Flusher  = (*trackingResponseWriter)(nil)
+	_ http.Hijacker = (*trackingResponseWriter)(nil)
+
+	errHijackNotSupported = errors.New("the response writer does not support hijacking")
+)
+
+func (t *trackingResponseWriter) WriteHeader(statusCode int) {
+	if t.statusCode == 0 {
+		t.statusCode = statusCode
+	}
+	t.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (t *trackingResponseWriter) Write(b []byte) (int, error) {
+	if t.statusCode == 0 {
+		t.statusCode = http.StatusOK
+	}
+	n, err := t.ResponseWriter.Write(b)
+	t.size += int64(n)
+	return n, err
+}
+
+func (t *trackingResponseWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (t *trackingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := t.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackNotSupported
+	}
+	return h.Hijack()
+}
+
+// Unwrap exposes the wrapped writer to the http.ResponseController
+func (t *trackingResponseWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+func (t *trackingResponseWriter) status() int {
+	if t.statusCode == 0 {
+		return http.StatusOK
+	}
+	return t.statusCode
+}
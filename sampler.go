@@ -0,0 +1,442 @@
+package opencensus
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+)
+
+// SamplingRule defines the sample rate to apply to the requests matching all its conditions.
+// Empty conditions match any request.
+type SamplingRule struct {
+	// Path is a glob pattern (as in path.Match) matched against the request path
+	Path string `json:"path"`
+	// PathRegexp is a regular expression matched against the request path
+	PathRegexp string `json:"path_regexp"`
+	// Methods lists the accepted HTTP methods
+	Methods []string `json:"methods"`
+	// Host is a glob pattern (as in path.Match) matched against the request host
+	Host string `json:"host"`
+	// Headers maps header names to their expected values. An empty value only checks the
+	// presence of the header
+	Headers map[string]string `json:"headers"`
+	// SampleRate is the percentage of matching requests to sample
+	SampleRate int `json:"sample_rate"`
+}
+
+// SamplerForRequest returns the sampler of the first sampling rule matching the request, or
+// nil if no rule matches and the endpoint or the global sampler should be used
+func SamplerForRequest(r *http.Request) trace.Sampler {
+	rs := currentRuleSampler.Load()
+	if rs == nil {
+		return nil
+	}
+	return rs.sampler(r)
+}
+
+var currentRuleSampler atomic.Pointer[ruleSampler]
+
+type ruleSampler struct {
+	rules []compiledSamplingRule
+}
+
+type compiledSamplingRule struct {
+	SamplingRule
+	pathRegexp *regexp.Regexp
+	sampler    trace.Sampler
+}
+
+func newRuleSampler(rules []SamplingRule) (*ruleSampler, error) {
+	rs := &ruleSampler{rules: make([]compiledSamplingRule, len(rules))}
+	for i, rule := range rules {
+		if _, err := path.Match(rule.Path, ""); err != nil {
+			return nil, fmt.Errorf("sampling rule #%d: bad path pattern %q: %w", i, rule.Path, err)
+		}
+		if _, err := path.Match(rule.Host, ""); err != nil {
+			return nil, fmt.Errorf("sampling rule #%d: bad host pattern %q: %w", i, rule.Host, err)
+		}
+		c := compiledSamplingRule{
+			SamplingRule: rule,
+			sampler:      samplerFromRate(rule.SampleRate),
+		}
+		if rule.PathRegexp != "" {
+			re, err := regexp.Compile(rule.PathRegexp)
+			if err != nil {
+				return nil, fmt.Errorf("sampling rule #%d: %w", i, err)
+			}
+			c.pathRegexp = re
+		}
+		rs.rules[i] = c
+	}
+	return rs, nil
+}
+
+func (rs *ruleSampler) sampler(r *http.Request) trace.Sampler {
+	for _, rule := range rs.rules {
+		if rule.matches(r) {
+			return rule.sampler
+		}
+	}
+	return nil
+}
+
+func (c compiledSamplingRule) matches(r *http.Request) bool {
+	if c.Path != "" {
+		if ok, _ := path.Match(c.Path, r.URL.Path); !ok {
+			return false
+		}
+	}
+	if c.pathRegexp != nil && !c.pathRegexp.MatchString(r.URL.Path) {
+		return false
+	}
+	if c.Host != "" {
+		if ok, _ := path.Match(c.Host, r.Host); !ok {
+			return false
+		}
+	}
+	if len(c.Methods) > 0 && !containsFold(c.Methods, r.Method) {
+		return false
+	}
+	return matchHeaders(r, c.Headers)
+}
+
+// matchHeaders reports if the request carries all the headers with the expected values. An
+// empty value only checks the presence of the header
+func matchHeaders(r *http.Request, headers map[string]string) bool {
+	for name, value := range headers {
+		values, ok := r.Header[http.CanonicalHeaderKey(name)]
+		if !ok {
+			return false
+		}
+		if value != "" && !contains(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, v string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	samplerTypeProbabilistic = "probabilistic"
+	samplerTypeRateLimiting  = "rate_limiting"
+
+	// maxRateLimiterBuckets caps the buckets of the per endpoint rate limiter, since the span
+	// names may contain values sent by the clients. The names seen after reaching it share
+	// a single bucket.
+	maxRateLimiterBuckets = 1024
+)
+
+// SamplerConfig selects the sampler applied on top of the sample rate
+type SamplerConfig struct {
+	// Type is the kind of sampler: "probabilistic" (default) or "rate_limiting"
+	Type string `json:"type"`
+	// MaxPerSecond is the number of root spans the rate limiting sampler allows to sample per second
+	MaxPerSecond float64 `json:"max_per_second"`
+	// PerEndpoint applies the limit to every span name instead of to the whole gateway. Up to
+	// 1024 names get their own limit; the rest share a single one
+	PerEndpoint bool `json:"per_endpoint"`
+}
+
+var (
+	// SamplerDecisions counts the sampling decisions taken by the rate limiting sampler
+	SamplerDecisions = stats.Int64(
+		"krakend.io/opencensus/sampler/decisions",
+		"Number of sampling decisions taken by the rate limiting sampler",
+		stats.UnitDimensionless,
+	)
+
+	// KeySamplerDecision is the outcome of a sampling decision: sampled or dropped
+	KeySamplerDecision = tag.MustNewKey("sampler_decision")
+
+	SamplerDecisionsView = &view.View{
+		Name:        "krakend.io/opencensus/sampler/decisions",
+		Description: "Count of sampling decisions by outcome",
+		TagKeys:     []tag.Key{KeySamplerDecision},
+		Measure:     SamplerDecisions,
+		Aggregation: view.Count(),
+	}
+
+	currentRateLimiter atomic.Pointer[rateLimiter]
+)
+
+// rateLimited caps the sampling decisions of the base sampler with the current rate limiter.
+// Spans with a local parent just inherit its decision. When the tail sampler is enabled, every
+// trace is recorded so it reaches the tail sampler, and the decision of the base sampler and
+// the rate limiter is only kept as the one to propagate downstream.
+func rateLimited(base trace.Sampler) trace.Sampler {
+	return func(p trace.SamplingParameters) trace.SamplingDecision {
+		if !tailSamplingEnabled.Load() {
+			return headSample(base, p)
+		}
+		if !p.HasRemoteParent && p.ParentContext != (trace.SpanContext{}) {
+			return trace.SamplingDecision{Sample: p.ParentContext.IsSampled()}
+		}
+		currentHeadDecisions.Load().remember(p.TraceID, headSample(base, p).Sample)
+		return trace.SamplingDecision{Sample: true}
+	}
+}
+
+func headSample(base trace.Sampler, p trace.SamplingParameters) trace.SamplingDecision {
+	rl := currentRateLimiter.Load()
+	if rl == nil {
+		return base(p)
+	}
+	if p.ParentContext.IsSampled() {
+		return trace.SamplingDecision{Sample: true}
+	}
+	if !p.HasRemoteParent && p.ParentContext != (trace.SpanContext{}) {
+		return trace.SamplingDecision{Sample: false}
+	}
+	if !base(p).Sample {
+		return trace.SamplingDecision{Sample: false}
+	}
+	if !rl.allow(p.Name) {
+		recordSamplerDecision("dropped")
+		return trace.SamplingDecision{Sample: false}
+	}
+	recordSamplerDecision("sampled")
+	return trace.SamplingDecision{Sample: true}
+}
+
+func recordSamplerDecision(decision string) {
+	stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(KeySamplerDecision, decision)}, SamplerDecisions.M(1))
+}
+
+type rateLimiter struct {
+	perSecond   float64
+	perEndpoint bool
+	global      *tokenBucket
+	overflow    *tokenBucket
+	mu          *sync.Mutex
+	buckets     map[string]*tokenBucket
+	now         func() time.Time
+}
+
+// validateSampler rejects the sampler configurations newRateLimiter would silently ignore
+func validateSampler(cfg *SamplerConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	switch cfg.Type {
+	case "", samplerTypeProbabilistic:
+		return nil
+	case samplerTypeRateLimiting:
+		if cfg.MaxPerSecond <= 0 {
+			return fmt.Errorf("the rate limiting sampler requires a positive max_per_second, got %v", cfg.MaxPerSecond)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown sampler type %q", cfg.Type)
+	}
+}
+
+func newRateLimiter(cfg *SamplerConfig) *rateLimiter {
+	if cfg == nil || cfg.Type != samplerTypeRateLimiting {
+		return nil
+	}
+	rl := &rateLimiter{
+		perSecond:   cfg.MaxPerSecond,
+		perEndpoint: cfg.PerEndpoint,
+		mu:          new(sync.Mutex),
+		buckets:     map[string]*tokenBucket{},
+		now:         time.Now,
+	}
+	rl.global = newTokenBucket(rl.perSecond, rl.now())
+	rl.overflow = newTokenBucket(rl.perSecond, rl.now())
+	return rl
+}
+
+func (rl *rateLimiter) allow(name string) bool {
+	now := rl.now()
+	if !rl.perEndpoint {
+		return rl.global.take(now)
+	}
+	rl.mu.Lock()
+	b, ok := rl.buckets[name]
+	if !ok {
+		if len(rl.buckets) < maxRateLimiterBuckets {
+			b = newTokenBucket(rl.perSecond, now)
+			rl.buckets[name] = b
+		} else {
+			b = rl.overflow
+		}
+	}
+	rl.mu.Unlock()
+	return b.take(now)
+}
+
+// tokenBucket refills rate tokens per second up to a burst of max(rate, 1)
+type tokenBucket struct {
+	mu     *sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		mu:     new(sync.Mutex),
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) take(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// ForcedSamplingAttribute flags the spans sampled because of the debug header
+const ForcedSamplingAttribute = "sampling.forced"
+
+// DebugHeaderConfig defines the request header forcing the sampling of a request. At least
+// one of the protections (secret or allowed networks) is required, and all the configured
+// ones must pass.
+type DebugHeaderConfig struct {
+	// Name of the header, e.g. X-Debug-Trace
+	Name string `json:"name"`
+	// Secret is the value the header must carry. Without it, any value is accepted
+	Secret string `json:"secret"`
+	// AllowedCIDRs lists the networks the request must come from
+	AllowedCIDRs []string `json:"allowed_cidrs"`
+}
+
+var (
+	errDebugHeaderWithoutName       = errors.New("the debug header requires a name")
+	errDebugHeaderWithoutProtection = errors.New("the debug header requires a secret or a list of allowed networks")
+
+	currentDebugHeader atomic.Pointer[debugHeader]
+)
+
+// ForceSampling reports if the request asks for its trace to be sampled through a valid
+// debug header
+func ForceSampling(r *http.Request) bool {
+	dh := currentDebugHeader.Load()
+	return dh != nil && dh.forces(r)
+}
+
+type debugHeader struct {
+	name     string
+	secret   []byte
+	networks []*net.IPNet
+}
+
+func newDebugHeader(cfg *DebugHeaderConfig) (*debugHeader, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.Name == "" {
+		return nil, errDebugHeaderWithoutName
+	}
+	if cfg.Secret == "" && len(cfg.AllowedCIDRs) == 0 {
+		return nil, errDebugHeaderWithoutProtection
+	}
+	networks, err := parseCIDRs(cfg.AllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	return &debugHeader{
+		name:     http.CanonicalHeaderKey(cfg.Name),
+		secret:   []byte(cfg.Secret),
+		networks: networks,
+	}, nil
+}
+
+func (dh *debugHeader) forces(r *http.Request) bool {
+	value := r.Header.Get(dh.name)
+	if value == "" {
+		return false
+	}
+	if len(dh.secret) > 0 && subtle.ConstantTimeCompare([]byte(value), dh.secret) != 1 {
+		return false
+	}
+	if len(dh.networks) > 0 && !containsIP(dh.networks, remoteIP(r)) {
+		return false
+	}
+	return true
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks[i] = network
+	}
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the address of the peer. Forwarding headers are ignored, since any
+// client can set them.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,219 @@
+package opencensus
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.opencensus.io/trace"
+)
+
+func TestRuleSampler(t *testing.T) {
+	rs, err := newRuleSampler([]SamplingRule{
+		{
+			Path:       "/payment/*",
+			Methods:    []string{"post"},
+			SampleRate: 100,
+		},
+		{
+			PathRegexp: "^/catalog/",
+			Methods:    []string{"GET"},
+			SampleRate: 0,
+		},
+		{
+			Host:       "*.internal",
+			Headers:    map[string]string{"X-Debug": ""},
+			SampleRate: 100,
+		},
+		{
+			Headers:    map[string]string{"X-Tier": "free"},
+			SampleRate: 0,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range []struct {
+		method  string
+		url     string
+		headers map[string]string
+		matches bool
+		sampled bool
+	}{
+		{method: "POST", url: "http://example.tld/payment/123", matches: true, sampled: true},
+		{method: "GET", url: "http://example.tld/payment/123"},
+		{method: "POST", url: "http://example.tld/payment/123/refund"},
+		{method: "GET", url: "http://example.tld/catalog/items/42", matches: true, sampled: false},
+		{method: "GET", url: "http://api.internal/foo", headers: map[string]string{"X-Debug": "yes"}, matches: true, sampled: true},
+		{method: "GET", url: "http://api.internal/foo"},
+		{method: "GET", url: "http://example.tld/foo", headers: map[string]string{"X-Tier": "free"}, matches: true, sampled: false},
+		{method: "GET", url: "http://example.tld/foo", headers: map[string]string{"X-Tier": "gold"}},
+	} {
+		r, _ := http.NewRequest(tc.method, tc.url, http.NoBody)
+		for k, v := range tc.headers {
+			r.Header.Set(k, v)
+		}
+		sampler := rs.sampler(r)
+		if !tc.matches {
+			if sampler != nil {
+				t.Errorf("tc-%d: unexpected match", i)
+			}
+			continue
+		}
+		if sampler == nil {
+			t.Errorf("tc-%d: the request should match", i)
+			continue
+		}
+		if d := sampler(trace.SamplingParameters{}); d.Sample != tc.sampled {
+			t.Errorf("tc-%d: unexpected decision: %v", i, d.Sample)
+		}
+	}
+}
+
+func TestNewRuleSampler_badRule(t *testing.T) {
+	if _, err := newRuleSampler([]SamplingRule{{PathRegexp: "^/foo/(bar"}}); err == nil {
+		t.Error("error expected")
+	}
+	if _, err := newRuleSampler([]SamplingRule{{Path: "/foo/[bar"}}); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	defer currentRateLimiter.Store(nil)
+
+	now := time.Now()
+	rl := newRateLimiter(&SamplerConfig{Type: "rate_limiting", MaxPerSecond: 2, PerEndpoint: true})
+	rl.now = func() time.Time { return now }
+	currentRateLimiter.Store(rl)
+
+	sampler := samplerFromRate(100)
+	sample := func(name string) bool {
+		return sampler(trace.SamplingParameters{Name: name}).Sample
+	}
+
+	for i, expected := range []bool{true, true, false, false} {
+		if got := sample("/foo"); got != expected {
+			t.Errorf("foo-%d: unexpected decision %v", i, got)
+		}
+	}
+	if !sample("/bar") {
+		t.Error("each endpoint should have its own bucket")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if !sample("/foo") {
+		t.Error("the bucket should have been refilled")
+	}
+	if sample("/foo") {
+		t.Error("the bucket should be empty")
+	}
+
+	parent := trace.SpanContext{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{1}, TraceOptions: 1}
+	if !sampler(trace.SamplingParameters{Name: "/foo", ParentContext: parent}).Sample {
+		t.Error("sampled parents should be honoured")
+	}
+	parent.TraceOptions = 0
+	if sampler(trace.SamplingParameters{Name: "/foo", ParentContext: parent}).Sample {
+		t.Error("children of unsampled local parents should not be sampled")
+	}
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	if rl := newRateLimiter(nil); rl != nil {
+		t.Error("unexpected rate limiter")
+	}
+	if rl := newRateLimiter(&SamplerConfig{Type: "probabilistic"}); rl != nil {
+		t.Error("unexpected rate limiter")
+	}
+}
+
+func TestRateLimiter_maxBuckets(t *testing.T) {
+	now := time.Now()
+	rl := newRateLimiter(&SamplerConfig{Type: "rate_limiting", MaxPerSecond: 1, PerEndpoint: true})
+	rl.now = func() time.Time { return now }
+
+	for i := 0; i < maxRateLimiterBuckets; i++ {
+		if !rl.allow(fmt.Sprintf("/path/%d", i)) {
+			t.Fatalf("the name #%d should get its own bucket", i)
+		}
+	}
+	if !rl.allow("/overflow/1") {
+		t.Error("the overflow bucket should have a token")
+	}
+	if rl.allow("/overflow/2") {
+		t.Error("the names over the limit should share the overflow bucket")
+	}
+	if len(rl.buckets) != maxRateLimiterBuckets {
+		t.Errorf("unexpected number of buckets: %d", len(rl.buckets))
+	}
+}
+
+func TestValidateSampler(t *testing.T) {
+	for i, tc := range []struct {
+		cfg *SamplerConfig
+		ok  bool
+	}{
+		{ok: true},
+		{cfg: &SamplerConfig{}, ok: true},
+		{cfg: &SamplerConfig{Type: "probabilistic"}, ok: true},
+		{cfg: &SamplerConfig{Type: "rate_limiting", MaxPerSecond: 0.5}, ok: true},
+		{cfg: &SamplerConfig{Type: "rate_limiting"}},
+		{cfg: &SamplerConfig{Type: "rate_limiting", MaxPerSecond: -1}},
+		{cfg: &SamplerConfig{Type: "rate-limiting", MaxPerSecond: 10}},
+	} {
+		if err := validateSampler(tc.cfg); (err == nil) != tc.ok {
+			t.Errorf("tc-%d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestDebugHeader(t *testing.T) {
+	dh, err := newDebugHeader(&DebugHeaderConfig{
+		Name:         "X-Debug-Trace",
+		Secret:       "s3cr3t",
+		AllowedCIDRs: []string{"10.0.0.0/8", "::1/128"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range []struct {
+		remoteAddr string
+		value      string
+		forced     bool
+	}{
+		{remoteAddr: "10.1.2.3:1234", value: "s3cr3t", forced: true},
+		{remoteAddr: "[::1]:1234", value: "s3cr3t", forced: true},
+		{remoteAddr: "10.1.2.3:1234", value: "1"},
+		{remoteAddr: "10.1.2.3:1234"},
+		{remoteAddr: "192.168.1.1:1234", value: "s3cr3t"},
+		{remoteAddr: "garbage", value: "s3cr3t"},
+	} {
+		r, _ := http.NewRequest("GET", "http://example.tld/foo", http.NoBody)
+		r.RemoteAddr = tc.remoteAddr
+		if tc.value != "" {
+			r.Header.Set("X-Debug-Trace", tc.value)
+		}
+		if got := dh.forces(r); got != tc.forced {
+			t.Errorf("tc-%d: unexpected result %v", i, got)
+		}
+	}
+}
+
+func TestNewDebugHeader_badConfig(t *testing.T) {
+	for i, cfg := range []*DebugHeaderConfig{
+		{Secret: "foo"},
+		{Name: "X-Debug-Trace"},
+		{Name: "X-Debug-Trace", AllowedCIDRs: []string{"10.0.0.0"}},
+	} {
+		if _, err := newDebugHeader(cfg); err == nil {
+			t.Errorf("tc-%d: error expected", i)
+		}
+	}
+	if dh, err := newDebugHeader(nil); dh != nil || err != nil {
+		t.Errorf("unexpected result: %v, %v", dh, err)
+	}
+}
@@ -0,0 +1,186 @@
+package opencensus
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/luraproject/lura/v2/config"
+	"go.opencensus.io/trace"
+)
+
+// SpanHeadersConfig lists the headers recorded as attributes of the router and the client spans.
+// The values of the sensitive headers are always redacted.
+type SpanHeadersConfig struct {
+	// Request lists the request headers to record
+	Request []string `json:"request"`
+	// Response lists the response headers to record
+	Response []string `json:"response"`
+	// SensitiveHeaders extends the default list of sensitive headers (Authorization, Cookie,
+	// Set-Cookie, the debug header and any header containing auth, token, secret, password or
+	// api key in its name)
+	SensitiveHeaders []string `json:"sensitive_headers"`
+	// HashSensitive records an HMAC of the sensitive values instead of redacting them, so they
+	// can be correlated without being exposed. It requires a HashKey
+	HashSensitive bool `json:"hash_sensitive"`
+	// HashKey is the secret key of the HMAC. The endpoints and backends without their own key
+	// use the global one, and redact the values if there is none
+	HashKey string `json:"hash_key"`
+}
+
+const (
+	requestHeaderAttributePrefix  = "http.request.header."
+	responseHeaderAttributePrefix = "http.response.header."
+	redactedHeaderValue           = "[REDACTED]"
+)
+
+var (
+	defaultSensitiveHeaders = []string{
+		"Authorization",
+		"Proxy-Authorization",
+		"Cookie",
+		"Set-Cookie",
+		"X-Auth-Token",
+		"X-Csrf-Token",
+	}
+	// sensitiveHeaderParts flags any header containing them as sensitive
+	sensitiveHeaderParts = []string{"api-key", "apikey", "api_key", "secret", "password", "token", "auth"}
+
+	currentSpanHeaders atomic.Pointer[SpanHeadersConfig]
+)
+
+// SpanHeaders records the allowlisted headers as span attributes
+type SpanHeaders struct {
+	request   []string
+	response  []string
+	sensitive map[string]struct{}
+	hashKey   []byte
+}
+
+var errMissingHashKey = errors.New("span headers: hash_sensitive requires a hash_key")
+
+// validateSpanHeaders rejects the global configuration hashing the values without a key
+func validateSpanHeaders(cfg *SpanHeadersConfig) error {
+	if cfg != nil && cfg.HashSensitive && cfg.HashKey == "" {
+		return errMissingHashKey
+	}
+	return nil
+}
+
+// GetSpanHeadersForEndpoint returns the headers to record in the router spans of the endpoint,
+// or nil if there are none. The span_headers of the endpoint replace the global ones.
+func GetSpanHeadersForEndpoint(cfg *config.EndpointConfig) *SpanHeaders {
+	shc := currentSpanHeaders.Load()
+	if extraCfg, err := parseEndpointConfig(cfg); err == nil && extraCfg.SpanHeaders != nil {
+		shc = extraCfg.SpanHeaders
+	}
+	return newSpanHeaders(shc)
+}
+
+// GetSpanHeadersForBackend returns the headers to record in the client spans of the backend,
+// or nil if there are none. The span_headers of the backend replace the global ones.
+func GetSpanHeadersForBackend(cfg *config.Backend) *SpanHeaders {
+	shc := currentSpanHeaders.Load()
+	if extraCfg, err := parseBackendConfig(cfg); err == nil && extraCfg.SpanHeaders != nil {
+		shc = extraCfg.SpanHeaders
+	}
+	return newSpanHeaders(shc)
+}
+
+func newSpanHeaders(cfg *SpanHeadersConfig) *SpanHeaders {
+	if cfg == nil || len(cfg.Request)+len(cfg.Response) == 0 {
+		return nil
+	}
+	sh := &SpanHeaders{
+		request:   canonicalHeaders(cfg.Request),
+		response:  canonicalHeaders(cfg.Response),
+		sensitive: map[string]struct{}{},
+	}
+	if cfg.HashSensitive {
+		key := cfg.HashKey
+		if global := currentSpanHeaders.Load(); key == "" && global != nil {
+			key = global.HashKey
+		}
+		if key == "" {
+			getLogger().Warning(logPrefix, errMissingHashKey.Error()+", redacting the sensitive values")
+		} else {
+			sh.hashKey = []byte(key)
+		}
+	}
+	for _, hs := range [][]string{defaultSensitiveHeaders, cfg.SensitiveHeaders} {
+		for _, h := range hs {
+			sh.sensitive[http.CanonicalHeaderKey(h)] = struct{}{}
+		}
+	}
+	return sh
+}
+
+func canonicalHeaders(headers []string) []string {
+	res := make([]string, len(headers))
+	for i, h := range headers {
+		res[i] = http.CanonicalHeaderKey(h)
+	}
+	return res
+}
+
+// RequestAttrs returns the attributes of the allowlisted request headers
+func (sh *SpanHeaders) RequestAttrs(h http.Header) []trace.Attribute {
+	if sh == nil {
+		return nil
+	}
+	return sh.attrs(requestHeaderAttributePrefix, sh.request, h)
+}
+
+// ResponseAttrs returns the attributes of the allowlisted response headers
+func (sh *SpanHeaders) ResponseAttrs(h http.Header) []trace.Attribute {
+	if sh == nil {
+		return nil
+	}
+	return sh.attrs(responseHeaderAttributePrefix, sh.response, h)
+}
+
+func (sh *SpanHeaders) attrs(prefix string, names []string, h http.Header) []trace.Attribute {
+	var attrs []trace.Attribute
+	for _, name := range names {
+		values, ok := h[name]
+		if !ok {
+			continue
+		}
+		value := strings.Join(values, ",")
+		if sh.isSensitive(name) {
+			value = sh.redact(value)
+		}
+		attrs = append(attrs, trace.StringAttribute(prefix+strings.ToLower(name), value))
+	}
+	return attrs
+}
+
+func (sh *SpanHeaders) isSensitive(name string) bool {
+	if _, ok := sh.sensitive[name]; ok {
+		return true
+	}
+	// the debug header may carry the secret forcing the sampling
+	if dh := currentDebugHeader.Load(); dh != nil && dh.name == name {
+		return true
+	}
+	lower := strings.ToLower(name)
+	for _, part := range sensitiveHeaderParts {
+		if strings.Contains(lower, part) {
+			return true
+		}
+	}
+	return false
+}
+
+func (sh *SpanHeaders) redact(value string) string {
+	if sh.hashKey == nil {
+		return redactedHeaderValue
+	}
+	mac := hmac.New(sha256.New, sh.hashKey)
+	mac.Write([]byte(value))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,152 @@
+package opencensus
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/luraproject/lura/v2/config"
+)
+
+func TestSpanHeaders(t *testing.T) {
+	h := http.Header{
+		"X-Request-Id":         {"abc"},
+		"Accept":               {"text/plain", "application/json"},
+		"Authorization":        {"Bearer token"},
+		"X-Api-Key":            {"key"},
+		"X-Tenant":             {"acme"},
+		"X-Custom":             {"private"},
+		"X-Access-Token":       {"t1"},
+		"X-Amz-Security-Token": {"t2"},
+		"X-Refresh-Token":      {"t3"},
+		"X-Auth-User":          {"user"},
+	}
+
+	for i, tc := range []struct {
+		cfg      *SpanHeadersConfig
+		expected map[string]string
+	}{
+		{cfg: nil},
+		{cfg: &SpanHeadersConfig{}},
+		{
+			cfg: &SpanHeadersConfig{Request: []string{"x-request-id", "accept", "x-missing"}},
+			expected: map[string]string{
+				"http.request.header.x-request-id": "abc",
+				"http.request.header.accept":       "text/plain,application/json",
+			},
+		},
+		{
+			cfg: &SpanHeadersConfig{
+				Request:          []string{"Authorization", "X-Api-Key", "X-Custom", "X-Tenant", "X-Access-Token", "X-Amz-Security-Token", "X-Refresh-Token", "X-Auth-User"},
+				SensitiveHeaders: []string{"x-custom"},
+			},
+			expected: map[string]string{
+				"http.request.header.authorization":        redactedHeaderValue,
+				"http.request.header.x-api-key":            redactedHeaderValue,
+				"http.request.header.x-custom":             redactedHeaderValue,
+				"http.request.header.x-tenant":             "acme",
+				"http.request.header.x-access-token":       redactedHeaderValue,
+				"http.request.header.x-amz-security-token": redactedHeaderValue,
+				"http.request.header.x-refresh-token":      redactedHeaderValue,
+				"http.request.header.x-auth-user":          redactedHeaderValue,
+			},
+		},
+	} {
+		sh := newSpanHeaders(tc.cfg)
+		attrs := sh.RequestAttrs(h)
+		if len(attrs) != len(tc.expected) {
+			t.Errorf("tc-%d: unexpected attributes: %v", i, attrs)
+			continue
+		}
+		for _, a := range attrs {
+			if v := a.Value(); v != tc.expected[a.Key()] {
+				t.Errorf("tc-%d: unexpected value for %s: %v", i, a.Key(), v)
+			}
+		}
+	}
+}
+
+func TestSpanHeaders_hash(t *testing.T) {
+	sh := newSpanHeaders(&SpanHeadersConfig{Response: []string{"Set-Cookie"}, HashSensitive: true, HashKey: "k3y"})
+	if attrs := sh.RequestAttrs(http.Header{"Set-Cookie": {"a=b"}}); len(attrs) != 0 {
+		t.Errorf("unexpected request attributes: %v", attrs)
+	}
+	attrs := sh.ResponseAttrs(http.Header{"Set-Cookie": {"session=secret"}})
+	if len(attrs) != 1 || attrs[0].Key() != "http.response.header.set-cookie" {
+		t.Fatalf("unexpected attributes: %v", attrs)
+	}
+	v, _ := attrs[0].Value().(string)
+	if !strings.HasPrefix(v, "hmac-sha256:") || strings.Contains(v, "secret") {
+		t.Errorf("unexpected hashed value: %s", v)
+	}
+	if other := sh.ResponseAttrs(http.Header{"Set-Cookie": {"session=secret"}}); other[0].Value() != v {
+		t.Error("the hash should be stable")
+	}
+
+	other := newSpanHeaders(&SpanHeadersConfig{Response: []string{"Set-Cookie"}, HashSensitive: true, HashKey: "other"})
+	if attrs := other.ResponseAttrs(http.Header{"Set-Cookie": {"session=secret"}}); attrs[0].Value() == v {
+		t.Error("the hash should depend on the key")
+	}
+}
+
+func TestSpanHeaders_hashWithoutKey(t *testing.T) {
+	defer currentSpanHeaders.Store(nil)
+
+	cfg := &SpanHeadersConfig{Request: []string{"Authorization"}, HashSensitive: true}
+	if err := validateSpanHeaders(cfg); err == nil {
+		t.Error("the global config should be rejected without a hash key")
+	}
+
+	h := http.Header{"Authorization": {"Bearer token"}}
+	if attrs := newSpanHeaders(cfg).RequestAttrs(h); len(attrs) != 1 || attrs[0].Value() != redactedHeaderValue {
+		t.Errorf("the values should be redacted without a hash key: %v", attrs)
+	}
+
+	currentSpanHeaders.Store(&SpanHeadersConfig{HashKey: "k3y"})
+	attrs := newSpanHeaders(cfg).RequestAttrs(h)
+	if v, _ := attrs[0].Value().(string); !strings.HasPrefix(v, "hmac-sha256:") {
+		t.Errorf("the global hash key should be used: %v", attrs)
+	}
+}
+
+func TestSpanHeaders_debugHeader(t *testing.T) {
+	defer currentDebugHeader.Store(nil)
+	dh, err := newDebugHeader(&DebugHeaderConfig{Name: "x-trace-me", Secret: "s3cr3t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentDebugHeader.Store(dh)
+
+	sh := newSpanHeaders(&SpanHeadersConfig{Request: []string{"X-Trace-Me"}})
+	attrs := sh.RequestAttrs(http.Header{"X-Trace-Me": {"s3cr3t"}})
+	if len(attrs) != 1 || attrs[0].Value() != redactedHeaderValue {
+		t.Errorf("the debug header should be redacted: %v", attrs)
+	}
+}
+
+func TestGetSpanHeadersForEndpoint(t *testing.T) {
+	defer currentSpanHeaders.Store(nil)
+	currentSpanHeaders.Store(&SpanHeadersConfig{Request: []string{"X-Global"}})
+
+	h := http.Header{"X-Global": {"global"}, "X-Local": {"local"}}
+
+	if attrs := GetSpanHeadersForEndpoint(&config.EndpointConfig{}).RequestAttrs(h); len(attrs) != 1 || attrs[0].Value() != "global" {
+		t.Errorf("unexpected global attributes: %v", attrs)
+	}
+
+	cfg := &config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{
+				"span_headers": map[string]interface{}{"request": []string{"X-Local"}},
+			},
+		},
+	}
+	if attrs := GetSpanHeadersForEndpoint(cfg).RequestAttrs(h); len(attrs) != 1 || attrs[0].Value() != "local" {
+		t.Errorf("unexpected endpoint attributes: %v", attrs)
+	}
+
+	cfg.ExtraConfig[Namespace] = map[string]interface{}{"span_headers": map[string]interface{}{}}
+	if sh := GetSpanHeadersForEndpoint(cfg); sh != nil {
+		t.Error("an empty override should disable the span headers")
+	}
+}
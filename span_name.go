@@ -0,0 +1,247 @@
+package opencensus
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/luraproject/lura/v2/config"
+)
+
+// SpanNamesConfig holds the span name templates of every layer. The templates accept the
+// placeholders {method}, {endpoint}, {url_pattern}, {host}, {group} and {path}, where {path}
+// is the aggregated path used as metric tag. {host} is always empty in the router spans, since
+// the Host header is chosen by the clients and would make the span names unbounded.
+type SpanNamesConfig struct {
+	Router  string `json:"router"`
+	Pipe    string `json:"pipe"`
+	Backend string `json:"backend"`
+	Client  string `json:"client"`
+}
+
+const (
+	defaultRouterSpanName  = "{endpoint}"
+	defaultPipeSpanName    = "pipe-{endpoint}"
+	defaultBackendSpanName = "backend-{url_pattern}"
+)
+
+var currentSpanNames atomic.Pointer[SpanNamesConfig]
+
+// GetSpanNameForEndpoint returns the function naming the router spans of the endpoint
+func GetSpanNameForEndpoint(cfg *config.EndpointConfig) func(*http.Request) string {
+	if cfg == nil {
+		cfg = new(config.EndpointConfig)
+	}
+	tmpl := spanNameTemplate(endpointSpanNames(cfg), func(c *SpanNamesConfig) string { return c.Router }, defaultRouterSpanName)
+	pathExtractor := GetAggregatedPathForMetrics(cfg)
+	suffix := spanNameQuerySuffix(tmpl, endpointQueryParams(cfg))
+	f := newSpanNameFormat(tmpl, map[string]string{
+		"{endpoint}":    cfg.Endpoint,
+		"{url_pattern}": "",
+		"{host}":        "",
+		"{group}":       "",
+	})
+	return func(r *http.Request) string {
+		return suffix(r, f.format(func(placeholder string) string {
+			if placeholder == "{method}" {
+				return r.Method
+			}
+			return pathExtractor(r)
+		}))
+	}
+}
+
+// GetPipeSpanName returns the name of the pipe spans of the endpoint
+func GetPipeSpanName(cfg *config.EndpointConfig) string {
+	tmpl := spanNameTemplate(endpointSpanNames(cfg), func(c *SpanNamesConfig) string { return c.Pipe }, defaultPipeSpanName)
+	return newSpanNameFormat(tmpl, map[string]string{
+		"{method}":      cfg.Method,
+		"{endpoint}":    cfg.Endpoint,
+		"{url_pattern}": "",
+		"{host}":        "",
+		"{group}":       "",
+		"{path}":        strings.ToLower(replaceMetricPath.ReplaceAllString(cfg.Endpoint, `{$1}`)),
+	}).format(nil)
+}
+
+// GetBackendSpanName returns the name of the backend spans
+func GetBackendSpanName(cfg *config.Backend) string {
+	tmpl := spanNameTemplate(backendSpanNames(cfg), func(c *SpanNamesConfig) string { return c.Backend }, defaultBackendSpanName)
+	values := backendPlaceholders(cfg)
+	values["{method}"] = cfg.Method
+	values["{host}"] = backendHost(cfg)
+	values["{path}"] = strings.ToLower(replaceMetricBackendPath.ReplaceAllString(cfg.URLPattern, `{$1}`))
+	return newSpanNameFormat(tmpl, values).format(nil)
+}
+
+// GetSpanNameForBackendRequest returns the function naming the client spans of the backend,
+// or nil if the default formatter (SpanNameFromURL) should be used
+func GetSpanNameForBackendRequest(cfg *config.Backend) func(*http.Request) string {
+	tmpl := spanNameTemplate(backendSpanNames(cfg), func(c *SpanNamesConfig) string { return c.Client }, "")
+	params := backendQueryParams(cfg)
+	if tmpl == "" {
+		if len(params) == 0 {
+			return nil
+		}
+		suffix := queryParamsSuffix(params)
+		return func(r *http.Request) string { return SpanNameFromURL(r) + suffix(r) }
+	}
+	if cfg == nil {
+		cfg = new(config.Backend)
+	}
+	pathExtractor := GetAggregatedPathForBackendMetrics(cfg)
+	suffix := spanNameQuerySuffix(tmpl, params)
+	f := newSpanNameFormat(tmpl, backendPlaceholders(cfg))
+	return func(r *http.Request) string {
+		return suffix(r, f.format(func(placeholder string) string {
+			switch placeholder {
+			case "{method}":
+				return r.Method
+			case "{host}":
+				return r.URL.Host
+			}
+			return pathExtractor(r)
+		}))
+	}
+}
+
+// spanNameQuerySuffix returns a function appending the selected query parameters to the span
+// name, unless the template already includes them through the aggregated path
+func spanNameQuerySuffix(tmpl string, params []QueryParamConfig) func(*http.Request, string) string {
+	suffix := queryParamsSuffix(params)
+	if suffix == nil || strings.Contains(tmpl, "{path}") {
+		return func(_ *http.Request, name string) string { return name }
+	}
+	return func(r *http.Request, name string) string { return name + suffix(r) }
+}
+
+// backendPlaceholders returns the values of the placeholders known when the backend is built
+func backendPlaceholders(cfg *config.Backend) map[string]string {
+	return map[string]string{
+		"{endpoint}":    cfg.ParentEndpoint,
+		"{url_pattern}": cfg.URLPattern,
+		"{group}":       cfg.Group,
+	}
+}
+
+var spanNamePlaceholders = []string{"{method}", "{endpoint}", "{url_pattern}", "{host}", "{group}", "{path}"}
+
+// spanNameFormat is a span name template split at the placeholders only known per request,
+// so the names are built without parsing the template again
+type spanNameFormat []spanNamePart
+
+type spanNamePart struct {
+	literal     string
+	placeholder string
+}
+
+// newSpanNameFormat splits the template, replacing the placeholders with a static value
+func newSpanNameFormat(tmpl string, static map[string]string) spanNameFormat {
+	var f spanNameFormat
+	literal := new(strings.Builder)
+	flush := func() {
+		if literal.Len() > 0 {
+			f = append(f, spanNamePart{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(tmpl); {
+		placeholder := ""
+		for _, p := range spanNamePlaceholders {
+			if strings.HasPrefix(tmpl[i:], p) {
+				placeholder = p
+				break
+			}
+		}
+		if placeholder == "" {
+			literal.WriteByte(tmpl[i])
+			i++
+			continue
+		}
+		i += len(placeholder)
+		if v, ok := static[placeholder]; ok {
+			literal.WriteString(v)
+			continue
+		}
+		flush()
+		f = append(f, spanNamePart{placeholder: placeholder})
+	}
+	flush()
+	return f
+}
+
+// format builds the name, asking for the value of the remaining placeholders
+func (f spanNameFormat) format(value func(placeholder string) string) string {
+	if len(f) == 1 && f[0].placeholder == "" {
+		return f[0].literal
+	}
+	b := new(strings.Builder)
+	for _, part := range f {
+		if part.placeholder == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		b.WriteString(value(part.placeholder))
+	}
+	return b.String()
+}
+
+func backendHost(cfg *config.Backend) string {
+	if len(cfg.Host) == 0 {
+		return ""
+	}
+	u, err := url.Parse(cfg.Host[0])
+	if err != nil || u.Host == "" {
+		return cfg.Host[0]
+	}
+	return u.Host
+}
+
+func endpointQueryParams(cfg *config.EndpointConfig) []QueryParamConfig {
+	extraCfg, err := parseEndpointConfig(cfg)
+	if err != nil {
+		return nil
+	}
+	return extraCfg.QueryParams
+}
+
+func backendQueryParams(cfg *config.Backend) []QueryParamConfig {
+	extraCfg, err := parseBackendConfig(cfg)
+	if err != nil {
+		return nil
+	}
+	return extraCfg.QueryParams
+}
+
+func endpointSpanNames(cfg *config.EndpointConfig) *SpanNamesConfig {
+	extraCfg, err := parseEndpointConfig(cfg)
+	if err != nil {
+		return nil
+	}
+	return extraCfg.SpanNames
+}
+
+func backendSpanNames(cfg *config.Backend) *SpanNamesConfig {
+	extraCfg, err := parseBackendConfig(cfg)
+	if err != nil {
+		return nil
+	}
+	return extraCfg.SpanNames
+}
+
+// spanNameTemplate picks the template of the layer from the override, the global
+// configuration or the fallback, in this order
+func spanNameTemplate(override *SpanNamesConfig, layer func(*SpanNamesConfig) string, fallback string) string {
+	if override != nil {
+		if tmpl := layer(override); tmpl != "" {
+			return tmpl
+		}
+	}
+	if global := currentSpanNames.Load(); global != nil {
+		if tmpl := layer(global); tmpl != "" {
+			return tmpl
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,98 @@
+package opencensus
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/luraproject/lura/v2/config"
+)
+
+func TestSpanNames(t *testing.T) {
+	defer currentSpanNames.Store(nil)
+
+	endpoint := &config.EndpointConfig{Endpoint: "/api/:foo/:bar", Method: "GET"}
+	backend := &config.Backend{
+		URLPattern:     "/api/{{.Foo}}/{{.Bar}}",
+		Host:           []string{"http://backend.internal:8080"},
+		Group:          "users",
+		Method:         "GET",
+		ParentEndpoint: "/api/:foo/:bar",
+	}
+	r, _ := http.NewRequest("GET", "http://backend.internal:8080/api/foo/bar", http.NoBody)
+
+	if name := GetSpanNameForEndpoint(endpoint)(r); name != "/api/:foo/:bar" {
+		t.Errorf("unexpected router span name: %s", name)
+	}
+	if name := GetPipeSpanName(endpoint); name != "pipe-/api/:foo/:bar" {
+		t.Errorf("unexpected pipe span name: %s", name)
+	}
+	if name := GetBackendSpanName(backend); name != "backend-/api/{{.Foo}}/{{.Bar}}" {
+		t.Errorf("unexpected backend span name: %s", name)
+	}
+	if f := GetSpanNameForBackendRequest(backend); f != nil {
+		t.Error("the client spans should use the default formatter")
+	}
+
+	currentSpanNames.Store(&SpanNamesConfig{
+		Router:  "{method} {path}",
+		Pipe:    "pipe {method} {path}",
+		Backend: "{group}@{host}",
+		Client:  "{method} {host}{path}",
+	})
+
+	if name := GetSpanNameForEndpoint(endpoint)(r); name != "GET /api/{foo}/{bar}" {
+		t.Errorf("unexpected router span name: %s", name)
+	}
+	if name := GetPipeSpanName(endpoint); name != "pipe GET /api/{foo}/{bar}" {
+		t.Errorf("unexpected pipe span name: %s", name)
+	}
+	if name := GetBackendSpanName(backend); name != "users@backend.internal:8080" {
+		t.Errorf("unexpected backend span name: %s", name)
+	}
+	if name := GetSpanNameForBackendRequest(backend)(r); name != "GET backend.internal:8080/api/{foo}/{bar}" {
+		t.Errorf("unexpected client span name: %s", name)
+	}
+
+	backend.ExtraConfig = config.ExtraConfig{
+		Namespace: map[string]interface{}{
+			"span_names": map[string]interface{}{"backend": "backend {endpoint}"},
+		},
+	}
+	if name := GetBackendSpanName(backend); name != "backend /api/:foo/:bar" {
+		t.Errorf("unexpected overridden backend span name: %s", name)
+	}
+}
+
+func TestSpanNames_routerHost(t *testing.T) {
+	defer currentSpanNames.Store(nil)
+
+	currentSpanNames.Store(&SpanNamesConfig{Router: "{host} {method} {endpoint}"})
+	r, _ := http.NewRequest("POST", "http://attacker-controlled.tld/foo", http.NoBody)
+	if name := GetSpanNameForEndpoint(&config.EndpointConfig{Endpoint: "/foo"})(r); name != " POST /foo" {
+		t.Errorf("the client supplied host should be ignored: %s", name)
+	}
+}
+
+func TestSpanNameFormat(t *testing.T) {
+	for i, tc := range []struct {
+		tmpl     string
+		expected string
+	}{
+		{tmpl: "", expected: ""},
+		{tmpl: "static", expected: "static"},
+		{tmpl: "{endpoint}", expected: "/foo"},
+		{tmpl: "{method}{method} {endpoint}-{path}", expected: "GETGET /foo-/path"},
+		{tmpl: "{unknown} {method", expected: "{unknown} {method"},
+	} {
+		f := newSpanNameFormat(tc.tmpl, map[string]string{"{endpoint}": "/foo"})
+		name := f.format(func(placeholder string) string {
+			if placeholder == "{method}" {
+				return "GET"
+			}
+			return "/path"
+		})
+		if name != tc.expected {
+			t.Errorf("tc-%d: unexpected name %q", i, name)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package opencensus
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/trace"
+)
+
+// TailSamplingConfig defines the policies of the tail sampler. When it is enabled, every span
+// is recorded and buffered until the local root span of its trace ends, and the whole trace
+// is exported only if it matches any of the policies. The sampling flag propagated to the
+// backends and written in the trace response headers is still the one taken by the head
+// samplers (sample_rate, sampler and sampling_rules), so the downstream services keep
+// sampling the same share of the traffic.
+type TailSamplingConfig struct {
+	// MaxTraces is the maximum number of traces kept in memory waiting for a decision
+	MaxTraces int `json:"max_traces"`
+	// DecisionWait is the maximum time to wait for the root span of a trace
+	DecisionWait string `json:"decision_wait"`
+	// MinStatusCode keeps the traces with any span reporting an equal or higher HTTP status code
+	MinStatusCode int `json:"min_status_code"`
+	// MinDuration keeps the traces with a root span lasting at least this long
+	MinDuration string `json:"min_duration"`
+	// KeepErrors keeps the traces with any span flagged with an error attribute, answered with a
+	// 5xx status code, or failed with a server error status without status code
+	KeepErrors bool `json:"keep_errors"`
+	// SampleRate is the percentage of the remaining traces to keep
+	SampleRate int `json:"sample_rate"`
+}
+
+const (
+	defaultTailSamplingMaxTraces    = 10000
+	defaultTailSamplingDecisionWait = 10 * time.Second
+)
+
+var (
+	// tailSamplingEnabled tells the samplers to record every span, since the decision is taken
+	// by the tail sampler
+	tailSamplingEnabled atomic.Bool
+	// currentHeadDecisions keeps the decision of the head samplers while the tail sampler is enabled
+	currentHeadDecisions atomic.Pointer[headDecisions]
+)
+
+// headDecisions remembers the head sampling decision of the traces with a local root span in
+// progress, so it can be propagated instead of the forced one. The oldest decisions are
+// dropped when the limit is reached.
+type headDecisions struct {
+	max       int
+	mu        *sync.Mutex
+	decisions map[trace.TraceID]*list.Element
+	order     *list.List
+}
+
+type headDecision struct {
+	traceID trace.TraceID
+	sampled bool
+}
+
+func newHeadDecisions(max int) *headDecisions {
+	if max <= 0 {
+		max = defaultTailSamplingMaxTraces
+	}
+	return &headDecisions{
+		max:       max,
+		mu:        new(sync.Mutex),
+		decisions: map[trace.TraceID]*list.Element{},
+		order:     list.New(),
+	}
+}
+
+func (hd *headDecisions) remember(traceID trace.TraceID, sampled bool) {
+	if hd == nil {
+		return
+	}
+	hd.mu.Lock()
+	defer hd.mu.Unlock()
+	if e, ok := hd.decisions[traceID]; ok {
+		e.Value = headDecision{traceID: traceID, sampled: sampled}
+		return
+	}
+	if hd.order.Len() >= hd.max {
+		oldest := hd.order.Front()
+		delete(hd.decisions, oldest.Value.(headDecision).traceID)
+		hd.order.Remove(oldest)
+	}
+	hd.decisions[traceID] = hd.order.PushBack(headDecision{traceID: traceID, sampled: sampled})
+}
+
+func (hd *headDecisions) forget(traceID trace.TraceID) {
+	if hd == nil {
+		return
+	}
+	hd.mu.Lock()
+	defer hd.mu.Unlock()
+	if e, ok := hd.decisions[traceID]; ok {
+		delete(hd.decisions, traceID)
+		hd.order.Remove(e)
+	}
+}
+
+func (hd *headDecisions) sampled(traceID trace.TraceID) (bool, bool) {
+	if hd == nil {
+		return false, false
+	}
+	hd.mu.Lock()
+	defer hd.mu.Unlock()
+	e, ok := hd.decisions[traceID]
+	if !ok {
+		return false, false
+	}
+	return e.Value.(headDecision).sampled, true
+}
+
+// propagatedSpanContext returns the span context to send downstream, with the sampling flag
+// of the head samplers when the tail sampler forced the recording of the trace
+func propagatedSpanContext(sc trace.SpanContext) trace.SpanContext {
+	if !sc.IsSampled() {
+		return sc
+	}
+	if sampled, ok := currentHeadDecisions.Load().sampled(sc.TraceID); ok && !sampled {
+		sc.TraceOptions = 0
+	}
+	return sc
+}
+
+// tailSampler is a trace.Exporter buffering the spans of every trace and forwarding the
+// traces matching the policy to the actual exporters
+type tailSampler struct {
+	exporters []trace.Exporter
+	policy    tailSamplingPolicy
+	wait      time.Duration
+	maxTraces int
+	now       func() time.Time
+
+	mu           *sync.Mutex
+	traces       map[trace.TraceID]*bufferedTrace
+	pending      *list.List
+	decided      map[trace.TraceID]bool
+	decidedOrder *list.List
+}
+
+type bufferedTrace struct {
+	spans   []*trace.SpanData
+	arrival time.Time
+	elem    *list.Element
+}
+
+func newTailSampler(ctx context.Context, cfg TailSamplingConfig, exporters []trace.Exporter) *tailSampler {
+	ts := &tailSampler{
+		exporters:    exporters,
+		policy:       newTailSamplingPolicy(cfg),
+		wait:         parseDuration(cfg.DecisionWait, defaultTailSamplingDecisionWait),
+		maxTraces:    cfg.MaxTraces,
+		now:          time.Now,
+		mu:           new(sync.Mutex),
+		traces:       map[trace.TraceID]*bufferedTrace{},
+		pending:      list.New(),
+		decided:      map[trace.TraceID]bool{},
+		decidedOrder: list.New(),
+	}
+	if ts.maxTraces <= 0 {
+		ts.maxTraces = defaultTailSamplingMaxTraces
+	}
+	go ts.run(ctx)
+	return ts
+}
+
+// ExportSpan buffers the span until the decision for its trace is taken
+func (ts *tailSampler) ExportSpan(sd *trace.SpanData) {
+	ts.mu.Lock()
+	if keep, ok := ts.decided[sd.TraceID]; ok {
+		// late span of an already decided trace
+		ts.mu.Unlock()
+		if keep {
+			ts.forward([]*trace.SpanData{sd})
+		}
+		return
+	}
+
+	var toExport []*trace.SpanData
+	bt, ok := ts.traces[sd.TraceID]
+	if !ok {
+		if ts.pending.Len() >= ts.maxTraces {
+			toExport = ts.decide(ts.pending.Front().Value.(trace.TraceID))
+		}
+		bt = &bufferedTrace{arrival: ts.now()}
+		bt.elem = ts.pending.PushBack(sd.TraceID)
+		ts.traces[sd.TraceID] = bt
+	}
+	bt.spans = append(bt.spans, sd)
+
+	if isLocalRoot(sd) {
+		toExport = append(toExport, ts.decide(sd.TraceID)...)
+	}
+	ts.mu.Unlock()
+
+	ts.forward(toExport)
+}
+
+func (ts *tailSampler) run(ctx context.Context) {
+	ticker := time.NewTicker(ts.wait)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			ts.flush(func(_ *bufferedTrace) bool { return true })
+			return
+		case <-ticker.C:
+			deadline := ts.now().Add(-ts.wait)
+			ts.flush(func(bt *bufferedTrace) bool { return !bt.arrival.After(deadline) })
+		}
+	}
+}
+
+// flush takes the decision for the buffered traces accepted by the filter
+func (ts *tailSampler) flush(filter func(*bufferedTrace) bool) {
+	var toExport []*trace.SpanData
+	ts.mu.Lock()
+	for e := ts.pending.Front(); e != nil; {
+		next := e.Next()
+		traceID := e.Value.(trace.TraceID)
+		if filter(ts.traces[traceID]) {
+			toExport = append(toExport, ts.decide(traceID)...)
+		}
+		e = next
+	}
+	ts.mu.Unlock()
+
+	ts.forward(toExport)
+}
+
+// decide removes the trace from the buffer, remembers the decision for the late spans
+// and returns the spans to export. It must be called with the lock held.
+func (ts *tailSampler) decide(traceID trace.TraceID) []*trace.SpanData {
+	bt := ts.traces[traceID]
+	delete(ts.traces, traceID)
+	ts.pending.Remove(bt.elem)
+	currentHeadDecisions.Load().forget(traceID)
+
+	keep := ts.policy.keep(traceID, bt.spans)
+
+	if ts.decidedOrder.Len() >= ts.maxTraces {
+		oldest := ts.decidedOrder.Front()
+		delete(ts.decided, oldest.Value.(trace.TraceID))
+		ts.decidedOrder.Remove(oldest)
+	}
+	ts.decided[traceID] = keep
+	ts.decidedOrder.PushBack(traceID)
+
+	if !keep {
+		return nil
+	}
+	return bt.spans
+}
+
+func (ts *tailSampler) forward(spans []*trace.SpanData) {
+	for _, sd := range spans {
+		for _, e := range ts.exporters {
+			e.ExportSpan(sd)
+		}
+	}
+}
+
+type tailSamplingPolicy struct {
+	minStatusCode int64
+	minDuration   time.Duration
+	keepErrors    bool
+	threshold     uint64
+}
+
+func newTailSamplingPolicy(cfg TailSamplingConfig) tailSamplingPolicy {
+	p := tailSamplingPolicy{
+		minStatusCode: int64(cfg.MinStatusCode),
+		minDuration:   parseDuration(cfg.MinDuration, 0),
+		keepErrors:    cfg.KeepErrors,
+	}
+	switch {
+	case cfg.SampleRate >= 100:
+		p.threshold = 1 << 63
+	case cfg.SampleRate > 0:
+		p.threshold = uint64(float64(cfg.SampleRate) / 100.0 * (1 << 63))
+	}
+	return p
+}
+
+func (p tailSamplingPolicy) keep(traceID trace.TraceID, spans []*trace.SpanData) bool {
+	for _, sd := range spans {
+		if forced, ok := sd.Attributes[ForcedSamplingAttribute].(bool); ok && forced {
+			return true
+		}
+		if p.minStatusCode > 0 {
+			if code, ok := sd.Attributes[ochttp.StatusCodeAttribute].(int64); ok && code >= p.minStatusCode {
+				return true
+			}
+		}
+		if p.keepErrors && isServerError(sd) {
+			return true
+		}
+		if p.minDuration > 0 && isLocalRoot(sd) && sd.EndTime.Sub(sd.StartTime) >= p.minDuration {
+			return true
+		}
+	}
+	// same criteria as the trace.ProbabilitySampler, so all the instances keep the same traces
+	return binary.BigEndian.Uint64(traceID[0:8])>>1 < p.threshold
+}
+
+// isServerError tells if the span failed on the server side. The status of the spans with an
+// HTTP status code is ignored, since the 4xx ones also get a non-OK status
+func isServerError(sd *trace.SpanData) bool {
+	if _, ok := sd.Attributes["error"]; ok {
+		return true
+	}
+	if code, ok := sd.Attributes[ochttp.StatusCodeAttribute].(int64); ok {
+		return code >= 500
+	}
+	switch sd.Status.Code {
+	case trace.StatusCodeUnknown,
+		trace.StatusCodeDeadlineExceeded,
+		trace.StatusCodeInternal,
+		trace.StatusCodeUnavailable,
+		trace.StatusCodeDataLoss:
+		return true
+	}
+	return false
+}
+
+func isLocalRoot(sd *trace.SpanData) bool {
+	return sd.HasRemoteParent || sd.ParentSpanID == (trace.SpanID{})
+}
+
+// validateTailSampling rejects the durations parseDuration would silently replace
+func validateTailSampling(cfg *TailSamplingConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	for _, option := range [][2]string{{"decision_wait", cfg.DecisionWait}, {"min_duration", cfg.MinDuration}} {
+		if option[1] == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(option[1]); err != nil || d <= 0 {
+			return fmt.Errorf("tail sampling: bad %s %q: expecting a positive duration like \"500ms\"", option[0], option[1])
+		}
+	}
+	return nil
+}
+
+func parseDuration(s string, fallback time.Duration) time.Duration {
+	if s == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,284 @@
+package opencensus
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luraproject/lura/v2/config"
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/trace"
+)
+
+func TestTailSampler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	exporter := &spanRecorder{}
+	ts := newTailSampler(ctx, TailSamplingConfig{
+		MinStatusCode: 500,
+		MinDuration:   "1s",
+		KeepErrors:    true,
+		DecisionWait:  "1h",
+	}, []trace.Exporter{exporter})
+
+	start := time.Now()
+	newSpan := func(traceID byte, spanID, parentID byte, d time.Duration, attrs map[string]interface{}) *trace.SpanData {
+		sd := &trace.SpanData{
+			SpanContext: trace.SpanContext{TraceID: trace.TraceID{traceID}, SpanID: trace.SpanID{spanID}},
+			StartTime:   start,
+			EndTime:     start.Add(d),
+			Attributes:  attrs,
+		}
+		if parentID != 0 {
+			sd.ParentSpanID = trace.SpanID{parentID}
+		}
+		return sd
+	}
+
+	// fast and successful trace: dropped
+	ts.ExportSpan(newSpan(1, 2, 1, time.Millisecond, nil))
+	ts.ExportSpan(newSpan(1, 1, 0, time.Millisecond, nil))
+	if n := exporter.len(); n != 0 {
+		t.Errorf("unexpected number of exported spans: %d", n)
+	}
+
+	// backend failure: kept
+	ts.ExportSpan(newSpan(2, 2, 1, time.Millisecond, map[string]interface{}{ochttp.StatusCodeAttribute: int64(503)}))
+	if n := exporter.len(); n != 0 {
+		t.Errorf("the trace should be buffered until the root span ends. exported spans: %d", n)
+	}
+	ts.ExportSpan(newSpan(2, 1, 0, time.Millisecond, nil))
+	if n := exporter.len(); n != 2 {
+		t.Errorf("unexpected number of exported spans: %d", n)
+	}
+
+	// slow trace: kept
+	ts.ExportSpan(newSpan(3, 1, 0, 2*time.Second, nil))
+	if n := exporter.len(); n != 3 {
+		t.Errorf("unexpected number of exported spans: %d", n)
+	}
+
+	// error attribute: kept, including the late spans
+	ts.ExportSpan(newSpan(4, 1, 0, time.Millisecond, map[string]interface{}{"error": "boom"}))
+	ts.ExportSpan(newSpan(4, 2, 1, time.Millisecond, nil))
+	if n := exporter.len(); n != 5 {
+		t.Errorf("unexpected number of exported spans: %d", n)
+	}
+
+	// client errors: dropped
+	ts.ExportSpan(newSpan(6, 1, 0, time.Millisecond, map[string]interface{}{ochttp.StatusCodeAttribute: int64(404)}))
+	ts.ExportSpan(&trace.SpanData{
+		SpanContext: trace.SpanContext{TraceID: trace.TraceID{7}, SpanID: trace.SpanID{1}},
+		Status:      trace.Status{Code: trace.StatusCodeNotFound},
+		Attributes:  map[string]interface{}{ochttp.StatusCodeAttribute: int64(404)},
+	})
+	ts.ExportSpan(&trace.SpanData{
+		SpanContext: trace.SpanContext{TraceID: trace.TraceID{8}, SpanID: trace.SpanID{1}},
+		Status:      trace.Status{Code: trace.StatusCodeUnknown},
+		Attributes:  map[string]interface{}{ochttp.StatusCodeAttribute: int64(405)},
+	})
+	if n := exporter.len(); n != 5 {
+		t.Errorf("unexpected number of exported spans: %d", n)
+	}
+
+	// server error status without status code: kept
+	ts.ExportSpan(&trace.SpanData{
+		SpanContext: trace.SpanContext{TraceID: trace.TraceID{9}, SpanID: trace.SpanID{1}},
+		Status:      trace.Status{Code: trace.StatusCodeDeadlineExceeded},
+	})
+	if n := exporter.len(); n != 6 {
+		t.Errorf("unexpected number of exported spans: %d", n)
+	}
+
+	// late spans of a dropped trace are dropped too
+	ts.ExportSpan(newSpan(1, 3, 1, time.Millisecond, nil))
+	if n := exporter.len(); n != 6 {
+		t.Errorf("unexpected number of exported spans: %d", n)
+	}
+
+	// traces without root are decided when the context is canceled
+	ts.ExportSpan(newSpan(5, 2, 1, time.Millisecond, map[string]interface{}{"error": "boom"}))
+	cancel()
+	for i := 0; i < 100 && exporter.len() != 7; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := exporter.len(); n != 7 {
+		t.Errorf("unexpected number of exported spans: %d", n)
+	}
+}
+
+func TestTailSampler_maxTraces(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	exporter := &spanRecorder{}
+	ts := newTailSampler(ctx, TailSamplingConfig{MaxTraces: 2, SampleRate: 100, DecisionWait: "1h"}, []trace.Exporter{exporter})
+
+	for i := byte(1); i <= 3; i++ {
+		ts.ExportSpan(&trace.SpanData{
+			SpanContext:  trace.SpanContext{TraceID: trace.TraceID{i}, SpanID: trace.SpanID{2}},
+			ParentSpanID: trace.SpanID{1},
+		})
+	}
+	if n := exporter.len(); n != 1 {
+		t.Errorf("the oldest trace should be evicted. exported spans: %d", n)
+	}
+	if n := len(ts.traces); n != 2 {
+		t.Errorf("unexpected number of buffered traces: %d", n)
+	}
+}
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(sd *trace.SpanData) {
+	r.mu.Lock()
+	r.spans = append(r.spans, sd)
+	r.mu.Unlock()
+}
+
+func (r *spanRecorder) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.spans)
+}
+
+func TestRateLimited_tailSampling(t *testing.T) {
+	defer currentRateLimiter.Store(nil)
+	defer tailSamplingEnabled.Store(false)
+
+	rl := newRateLimiter(&SamplerConfig{Type: "rate_limiting", MaxPerSecond: 1})
+	currentRateLimiter.Store(rl)
+	tailSamplingEnabled.Store(true)
+
+	endpointOverride := GetSamplerForEndpoint(&config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{Namespace: map[string]interface{}{"sample_rate": 0}},
+	})
+	for i, sampler := range []trace.Sampler{samplerFromRate(0), samplerFromRate(100), endpointOverride} {
+		for j := 0; j < 3; j++ {
+			if !sampler(trace.SamplingParameters{Name: "/foo"}).Sample {
+				t.Errorf("tc-%d: every root span should be recorded for the tail sampler", i)
+			}
+		}
+		remote := trace.SamplingParameters{Name: "/foo", HasRemoteParent: true, ParentContext: trace.SpanContext{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{1}}}
+		if !sampler(remote).Sample {
+			t.Errorf("tc-%d: the unsampled remote parents should be ignored", i)
+		}
+		local := trace.SamplingParameters{Name: "/foo", ParentContext: trace.SpanContext{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{1}}}
+		if sampler(local).Sample {
+			t.Errorf("tc-%d: children of unsampled local parents should not be sampled", i)
+		}
+	}
+}
+
+func TestPropagatedSpanContext_tailSampling(t *testing.T) {
+	defer currentHeadDecisions.Store(nil)
+	defer tailSamplingEnabled.Store(false)
+
+	currentHeadDecisions.Store(newHeadDecisions(10))
+	tailSamplingEnabled.Store(true)
+
+	for i, tc := range []struct {
+		sampler  trace.Sampler
+		traceID  trace.TraceID
+		expected bool
+	}{
+		{sampler: samplerFromRate(0), traceID: trace.TraceID{1}},
+		{sampler: samplerFromRate(100), traceID: trace.TraceID{2}, expected: true},
+		// the spans without a head decision, like the forced ones, keep their flag
+		{sampler: trace.AlwaysSample(), traceID: trace.TraceID{3}, expected: true},
+	} {
+		if !tc.sampler(trace.SamplingParameters{Name: "/foo", TraceID: tc.traceID}).Sample {
+			t.Errorf("tc-%d: the span should be recorded", i)
+		}
+		sc := trace.SpanContext{TraceID: tc.traceID, SpanID: trace.SpanID{1}, TraceOptions: 1}
+		if sampled := propagatedSpanContext(sc).IsSampled(); sampled != tc.expected {
+			t.Errorf("tc-%d: unexpected propagated flag: %v", i, sampled)
+		}
+		h := http.Header{}
+		(&TraceResponseConfig{SampledHeader: "X-Sampled"}).write(h, sc)
+		if v := h.Get("X-Sampled"); (v == "1") != tc.expected {
+			t.Errorf("tc-%d: unexpected sampled header: %s", i, v)
+		}
+	}
+
+	// the decision is forgotten once the tail sampler decides the trace
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := newTailSampler(ctx, TailSamplingConfig{}, nil)
+	ts.ExportSpan(&trace.SpanData{SpanContext: trace.SpanContext{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{1}}})
+	if _, ok := currentHeadDecisions.Load().sampled(trace.TraceID{1}); ok {
+		t.Error("the head decision should be forgotten")
+	}
+}
+
+func TestLoad_tailSamplingWithoutTraceExporters(t *testing.T) {
+	defer func(r composableRegister, fs []namedExporterFactory) {
+		register = r
+		exporterFactories = fs
+		tailSamplingEnabled.Store(false)
+	}(register, exporterFactories)
+
+	var sampleRates []int
+	register = composableRegister{
+		viewExporter:            func(_ ...view.Exporter) {},
+		traceExporter:           func(_ ...trace.Exporter) {},
+		unregisterViewExporter:  func(_ ...view.Exporter) {},
+		unregisterTraceExporter: func(_ ...trace.Exporter) {},
+		registerViews:           func(_ ...*view.View) error { return nil },
+		unregisterViews:         func(_ ...*view.View) {},
+		setDefaultSampler:       func(rate int, _ *SamplerConfig) { sampleRates = append(sampleRates, rate) },
+		setReportingPeriod:      func(_ time.Duration) {},
+	}
+	cfg := Config{SampleRate: 10, TailSampling: &TailSamplingConfig{}}
+
+	registrationMu.Lock()
+	defer registrationMu.Unlock()
+	defer unregister()
+
+	exporterFactories = nil
+	if err := load(context.Background(), cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if tailSamplingEnabled.Load() {
+		t.Error("the tail sampling should be disabled without trace exporters")
+	}
+
+	exporterFactories = []namedExporterFactory{{
+		name:    "dummy",
+		factory: func(_ context.Context, _ Config) (interface{}, error) { return new(dummyExporter), nil },
+	}}
+	if err := load(context.Background(), cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !tailSamplingEnabled.Load() {
+		t.Error("the tail sampling should be enabled")
+	}
+	if len(sampleRates) != 2 || sampleRates[0] != 10 || sampleRates[1] != 100 {
+		t.Errorf("unexpected sample rates: %v", sampleRates)
+	}
+}
+
+func TestValidateTailSampling(t *testing.T) {
+	for i, tc := range []struct {
+		cfg *TailSamplingConfig
+		ok  bool
+	}{
+		{ok: true},
+		{cfg: &TailSamplingConfig{}, ok: true},
+		{cfg: &TailSamplingConfig{DecisionWait: "5s", MinDuration: "500ms"}, ok: true},
+		{cfg: &TailSamplingConfig{MinDuration: "500"}},
+		{cfg: &TailSamplingConfig{DecisionWait: "-1s"}},
+		{cfg: &TailSamplingConfig{DecisionWait: "soon"}},
+	} {
+		if err := validateTailSampling(tc.cfg); (err == nil) != tc.ok {
+			t.Errorf("tc-%d: unexpected error: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package opencensus
+
+import (
+	"encoding/hex"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/luraproject/lura/v2/config"
+	"go.opencensus.io/trace"
+)
+
+// TraceResponseConfig exposes the trace of every request to the clients through the
+// response headers
+type TraceResponseConfig struct {
+	// Header is the name of the response header with the trace id. Defaults to X-Trace-Id
+	// unless the traceresponse header is enabled
+	Header string `json:"header"`
+	// SampledHeader is the name of the optional response header with the sampling decision
+	SampledHeader string `json:"sampled_header"`
+	// TraceResponse adds the traceresponse header defined by the W3C Trace Context draft
+	TraceResponse bool `json:"traceresponse"`
+}
+
+const (
+	defaultTraceIDHeader = "X-Trace-Id"
+	traceResponseHeader  = "traceresponse"
+)
+
+var currentTraceResponse atomic.Pointer[TraceResponseConfig]
+
+func newTraceResponse(cfg *TraceResponseConfig) *TraceResponseConfig {
+	if cfg == nil {
+		return nil
+	}
+	res := *cfg
+	if res.Header == "" && !res.TraceResponse {
+		res.Header = defaultTraceIDHeader
+	}
+	return &res
+}
+
+// GetTraceResponseWriter returns the function adding the trace of the request to the response
+// headers, or nil if the trace response headers are not enabled for the endpoint
+func GetTraceResponseWriter(cfg *config.EndpointConfig) func(http.Header, trace.SpanContext) {
+	tr := currentTraceResponse.Load()
+	if tr == nil {
+		return nil
+	}
+	if extraCfg, err := parseEndpointConfig(cfg); err == nil && extraCfg.DisableTraceResponse {
+		return nil
+	}
+	return tr.write
+}
+
+func (tr *TraceResponseConfig) write(h http.Header, sc trace.SpanContext) {
+	if sc.TraceID == (trace.TraceID{}) {
+		return
+	}
+	sc = propagatedSpanContext(sc)
+	tid := hex.EncodeToString(sc.TraceID[:])
+	if tr.Header != "" {
+		h.Set(tr.Header, tid)
+	}
+	if tr.SampledHeader != "" {
+		h.Set(tr.SampledHeader, sampledFlag(sc))
+	}
+	if tr.TraceResponse {
+		h.Set(traceResponseHeader, "00-"+tid+"-"+hex.EncodeToString(sc.SpanID[:])+"-0"+sampledFlag(sc))
+	}
+}
@@ -0,0 +1,74 @@
+package opencensus
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/luraproject/lura/v2/config"
+	"go.opencensus.io/trace"
+)
+
+func TestGetTraceResponseWriter(t *testing.T) {
+	defer currentTraceResponse.Store(nil)
+
+	sc := trace.SpanContext{
+		TraceID:      trace.TraceID{0x5f, 0x0b, 0x1c, 0x2d, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+		SpanID:       trace.SpanID{0, 0, 0, 0, 0, 0, 0x30, 0x39},
+		TraceOptions: 1,
+	}
+	disabled := &config.EndpointConfig{
+		ExtraConfig: config.ExtraConfig{
+			Namespace: map[string]interface{}{"disable_trace_response": true},
+		},
+	}
+
+	for i, tc := range []struct {
+		cfg      *TraceResponseConfig
+		endpoint *config.EndpointConfig
+		expected http.Header
+	}{
+		{endpoint: &config.EndpointConfig{}},
+		{
+			cfg:      &TraceResponseConfig{},
+			endpoint: &config.EndpointConfig{},
+			expected: http.Header{"X-Trace-Id": {"5f0b1c2d0102030405060708090a0b0c"}},
+		},
+		{
+			cfg:      &TraceResponseConfig{Header: "X-Request-Trace", SampledHeader: "X-Trace-Sampled"},
+			endpoint: &config.EndpointConfig{},
+			expected: http.Header{
+				"X-Request-Trace": {"5f0b1c2d0102030405060708090a0b0c"},
+				"X-Trace-Sampled": {"1"},
+			},
+		},
+		{
+			cfg:      &TraceResponseConfig{TraceResponse: true},
+			endpoint: &config.EndpointConfig{},
+			expected: http.Header{"Traceresponse": {"00-5f0b1c2d0102030405060708090a0b0c-0000000000003039-01"}},
+		},
+		{
+			cfg:      &TraceResponseConfig{},
+			endpoint: disabled,
+		},
+	} {
+		currentTraceResponse.Store(newTraceResponse(tc.cfg))
+		write := GetTraceResponseWriter(tc.endpoint)
+		if (write == nil) != (tc.expected == nil) {
+			t.Errorf("tc-%d: unexpected writer", i)
+			continue
+		}
+		if write == nil {
+			continue
+		}
+		h := http.Header{}
+		write(h, sc)
+		if len(h) != len(tc.expected) {
+			t.Errorf("tc-%d: unexpected headers: %v", i, h)
+		}
+		for k := range tc.expected {
+			if v := h.Get(k); v != tc.expected.Get(k) {
+				t.Errorf("tc-%d: unexpected value for %s: %q", i, k, v)
+			}
+		}
+	}
+}
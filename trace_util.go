@@ -7,6 +7,12 @@ import (
 	"go.opencensus.io/trace"
 )
 
+// Attributes recorded in the server spans, complementing the ones defined by ochttp
+const (
+	RouteAttribute        = "http.route"
+	ResponseSizeAttribute = "http.response_size"
+)
+
 func SpanNameFromURL(req *http.Request) string {
 	return req.URL.Path
 }
@@ -1,6 +1,7 @@
 package opencensus
 
 import (
+	"go.opencensus.io/stats/view"
 	"go.opencensus.io/tag"
 )
 
@@ -12,3 +13,13 @@ func appendIfMissing(slice []tag.Key, i tag.Key) []tag.Key {
 	}
 	return append(slice, i)
 }
+
+func cloneViews(vs []*view.View) []*view.View {
+	res := make([]*view.View, len(vs))
+	for i, v := range vs {
+		c := *v
+		c.TagKeys = append([]tag.Key{}, v.TagKeys...)
+		res[i] = &c
+	}
+	return res
+}
@@ -0,0 +1,152 @@
+package opencensus
+
+import (
+	"fmt"
+
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// ViewConfig declares a view over any of the known measures. A declared view replaces the
+// default view with the same name.
+type ViewConfig struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Measure is the name of the measure to aggregate, e.g. opencensus.io/http/server/latency
+	Measure string `json:"measure"`
+	// Aggregation is one of count, sum, last_value or distribution
+	Aggregation string `json:"aggregation"`
+	// Buckets are the boundaries of the distribution aggregation
+	Buckets []float64 `json:"buckets"`
+	TagKeys []string  `json:"tag_keys"`
+}
+
+const (
+	aggregationCount        = "count"
+	aggregationSum          = "sum"
+	aggregationLastValue    = "last_value"
+	aggregationDistribution = "distribution"
+)
+
+// knownMeasures returns the measures the declarative views can use, indexed by name
+func knownMeasures() map[string]stats.Measure {
+	ms := map[string]stats.Measure{}
+	for _, m := range []stats.Measure{
+		ochttp.ClientRequestCount,
+		ochttp.ClientRequestBytes,
+		ochttp.ClientResponseBytes,
+		ochttp.ClientLatency,
+		ochttp.ClientSentBytes,
+		ochttp.ClientReceivedBytes,
+		ochttp.ClientRoundtripLatency,
+		ochttp.ServerRequestCount,
+		ochttp.ServerRequestBytes,
+		ochttp.ServerResponseBytes,
+		ochttp.ServerLatency,
+
+		PipeLatency,
+		PipeErrors,
+		PipeCanceled,
+		PipeIncomplete,
+		BackendLatency,
+		BackendErrors,
+		BackendCanceled,
+		BackendIncomplete,
+		ClientDNSLatency,
+		ClientConnectLatency,
+		ClientTLSLatency,
+		ClientTimeToFirstByte,
+
+		SamplerDecisions,
+		CardinalityOverflows,
+	} {
+		ms[m.Name()] = m
+	}
+	return ms
+}
+
+// applyViewsConfig removes the disabled views from vs and adds the declared ones, replacing
+// the views with the same name. The disabled views must be in vs, so the typos are reported
+func applyViewsConfig(vs []*view.View, declared []ViewConfig, disabled []string) ([]*view.View, error) {
+	known := make(map[string]struct{}, len(vs))
+	for _, v := range vs {
+		known[v.Name] = struct{}{}
+	}
+	skip := make(map[string]struct{}, len(disabled)+len(declared))
+	for _, name := range disabled {
+		if _, ok := known[name]; !ok {
+			return nil, fmt.Errorf("disabled view %q: unknown view", name)
+		}
+		skip[name] = struct{}{}
+	}
+
+	custom := make([]*view.View, len(declared))
+	measures := knownMeasures()
+	for i, cfg := range declared {
+		v, err := newView(cfg, measures)
+		if err != nil {
+			return nil, err
+		}
+		custom[i] = v
+		skip[v.Name] = struct{}{}
+	}
+
+	res := make([]*view.View, 0, len(vs)+len(custom))
+	for _, v := range vs {
+		if _, ok := skip[v.Name]; !ok {
+			res = append(res, v)
+		}
+	}
+	return append(res, custom...), nil
+}
+
+func newView(cfg ViewConfig, measures map[string]stats.Measure) (*view.View, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("view without name for the measure %q", cfg.Measure)
+	}
+	m, ok := measures[cfg.Measure]
+	if !ok {
+		return nil, fmt.Errorf("view %s: unknown measure %q", cfg.Name, cfg.Measure)
+	}
+
+	var aggregation *view.Aggregation
+	switch cfg.Aggregation {
+	case aggregationCount:
+		aggregation = view.Count()
+	case aggregationSum:
+		aggregation = view.Sum()
+	case aggregationLastValue:
+		aggregation = view.LastValue()
+	case aggregationDistribution:
+		if len(cfg.Buckets) == 0 {
+			return nil, fmt.Errorf("view %s: the distribution aggregation requires buckets", cfg.Name)
+		}
+		aggregation = view.Distribution(cfg.Buckets...)
+	default:
+		return nil, fmt.Errorf("view %s: unknown aggregation %q", cfg.Name, cfg.Aggregation)
+	}
+
+	keys := make([]tag.Key, len(cfg.TagKeys))
+	for i, name := range cfg.TagKeys {
+		k, err := tag.NewKey(name)
+		if err != nil {
+			return nil, fmt.Errorf("view %s: %w", cfg.Name, err)
+		}
+		keys[i] = k
+	}
+
+	description := cfg.Description
+	if description == "" {
+		description = m.Description()
+	}
+
+	return &view.View{
+		Name:        cfg.Name,
+		Description: description,
+		TagKeys:     keys,
+		Measure:     m,
+		Aggregation: aggregation,
+	}, nil
+}
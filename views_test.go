@@ -0,0 +1,88 @@
+package opencensus
+
+import (
+	"testing"
+
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/stats/view"
+)
+
+func TestApplyViewsConfig(t *testing.T) {
+	vs, err := applyViewsConfig(
+		[]*view.View{ochttp.ServerLatencyView, ochttp.ServerRequestCountView, ochttp.ClientCompletedCount},
+		[]ViewConfig{
+			{
+				Name:        ochttp.ServerLatencyView.Name,
+				Measure:     "opencensus.io/http/server/latency",
+				Aggregation: "distribution",
+				Buckets:     []float64{50, 100, 250},
+				TagKeys:     []string{"http.path"},
+			},
+			{
+				Name:        "krakend/server/request_bytes_sum",
+				Measure:     "opencensus.io/http/server/request_bytes",
+				Aggregation: "sum",
+			},
+		},
+		[]string{ochttp.ClientCompletedCount.Name},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, len(vs))
+	for i, v := range vs {
+		names[i] = v.Name
+	}
+	expected := []string{
+		ochttp.ServerRequestCountView.Name,
+		ochttp.ServerLatencyView.Name,
+		"krakend/server/request_bytes_sum",
+	}
+	if len(names) != len(expected) {
+		t.Fatalf("unexpected views: %v", names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("unexpected view #%d: %s", i, names[i])
+		}
+	}
+
+	latency := vs[1]
+	if latency == ochttp.ServerLatencyView {
+		t.Error("the default latency view should be replaced")
+	}
+	if bounds := latency.Aggregation.Buckets; len(bounds) != 3 || bounds[2] != 250 {
+		t.Errorf("unexpected buckets: %v", bounds)
+	}
+	if len(latency.TagKeys) != 1 || latency.TagKeys[0] != ochttp.Path {
+		t.Errorf("unexpected tag keys: %v", latency.TagKeys)
+	}
+	if vs[2].Description == "" {
+		t.Error("the description of the measure should be used by default")
+	}
+}
+
+func TestApplyViewsConfig_badConfig(t *testing.T) {
+	for i, cfg := range []ViewConfig{
+		{Measure: "opencensus.io/http/server/latency", Aggregation: "count"},
+		{Name: "foo", Measure: "unknown", Aggregation: "count"},
+		{Name: "foo", Measure: "opencensus.io/http/server/latency", Aggregation: "unknown"},
+		{Name: "foo", Measure: "opencensus.io/http/server/latency", Aggregation: "distribution"},
+		{Name: "foo", Measure: "opencensus.io/http/server/latency", Aggregation: "count", TagKeys: []string{""}},
+	} {
+		if _, err := applyViewsConfig(nil, []ViewConfig{cfg}, nil); err == nil {
+			t.Errorf("tc-%d: error expected", i)
+		}
+	}
+}
+
+func TestApplyViewsConfig_unknownDisabledView(t *testing.T) {
+	vs := []*view.View{ochttp.ServerLatencyView}
+	if _, err := applyViewsConfig(vs, nil, []string{"opencensus.io/http/server/latencyy"}); err == nil {
+		t.Error("error expected")
+	}
+	if res, err := applyViewsConfig(vs, nil, []string{ochttp.ServerLatencyView.Name}); err != nil || len(res) != 0 {
+		t.Errorf("unexpected result: %v, %v", res, err)
+	}
+}